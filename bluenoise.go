@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+const blueNoiseSize = 32
+
+var (
+	blueNoiseOnce       sync.Once
+	blueNoiseThresholds [][]float64
+)
+
+// blueNoiseMatrix returns a blue noise threshold map, generated on first use with the
+// void-and-cluster method. The generation is seeded, so the map is always the same.
+// Reference: Ulichney, "The void-and-cluster method for dither array generation" (1993).
+func blueNoiseMatrix() [][]float64 {
+	blueNoiseOnce.Do(func() {
+		blueNoiseThresholds = voidAndCluster(blueNoiseSize, 1.5, rand.New(rand.NewSource(1)))
+	})
+	return blueNoiseThresholds
+}
+
+func voidAndCluster(size int, sigma float64, rnd *rand.Rand) [][]float64 {
+	n := size * size
+
+	// gaussian weight for every toroidal offset, used to measure how clustered a pixel is
+	gauss := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			wx, wy := float64(minInt(dx, size-dx)), float64(minInt(dy, size-dy))
+			gauss[dy*size+dx] = math.Exp(-(wx*wx + wy*wy) / (2 * sigma * sigma))
+		}
+	}
+
+	pattern := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(i int) {
+		pattern[i] = !pattern[i]
+		sign := 1.0
+		if !pattern[i] {
+			sign = -1
+		}
+		ix, iy := i%size, i/size
+		for j := range energy {
+			dx, dy := (j%size-ix+size)%size, (j/size-iy+size)%size
+			energy[j] += sign * gauss[dy*size+dx]
+		}
+	}
+	// tightestCluster and largestVoid find the set and unset pixel with the highest and lowest energy
+	tightestCluster := func() int {
+		best := -1
+		for i := range pattern {
+			if pattern[i] && (best < 0 || energy[i] > energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for i := range pattern {
+			if !pattern[i] && (best < 0 || energy[i] < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// initial pattern: a tenth of random pixels, relaxed until evenly spread
+	ones := n / 10
+	for _, i := range rnd.Perm(n)[:ones] {
+		toggle(i)
+	}
+	for {
+		cluster := tightestCluster()
+		toggle(cluster)
+		void := largestVoid()
+		toggle(void)
+		if void == cluster {
+			break
+		}
+	}
+	initial := make([]bool, n)
+	copy(initial, pattern)
+	initialEnergy := make([]float64, n)
+	copy(initialEnergy, energy)
+
+	rank := make([]int, n)
+	// lower ranks: remove the tightest clusters from the initial pattern
+	for r := ones - 1; r >= 0; r-- {
+		cluster := tightestCluster()
+		rank[cluster] = r
+		toggle(cluster)
+	}
+	// higher ranks: fill the largest voids, starting again from the initial pattern
+	copy(pattern, initial)
+	copy(energy, initialEnergy)
+	for r := ones; r < n; r++ {
+		void := largestVoid()
+		rank[void] = r
+		toggle(void)
+	}
+
+	thresholds := make([][]float64, size)
+	for y := range thresholds {
+		thresholds[y] = make([]float64, size)
+		for x := range thresholds[y] {
+			thresholds[y][x] = (float64(rank[y*size+x]) + 0.5) / float64(n)
+		}
+	}
+	return thresholds
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
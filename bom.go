@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// BOMEntry is a line of the bill of materials, the pieces needed of a part in a color.
+type BOMEntry struct {
+	PartID string
+	LegoID int
+	Name   string
+	Hex    string
+	Count  int
+	// Percentage of the pieces of the mosaic that are of this part and color
+	Percentage float64
+}
+
+// billOfMaterials counts the pieces of every palette color in the grid, all of them of the given part.
+// The entries are sorted by count, the most used color first, and then by ID.
+func billOfMaterials(colors []LegoColor, grid [][]int, partID string) []BOMEntry {
+	counts := make([]int, len(colors))
+	var total int
+	for x := range grid {
+		for _, i := range grid[x] {
+			if i >= 0 {
+				counts[i]++
+				total++
+			}
+		}
+	}
+
+	var bom []BOMEntry
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		c := colors[i]
+		bom = append(bom, BOMEntry{
+			PartID:     partID,
+			LegoID:     c.LegoID,
+			Name:       c.Name,
+			Hex:        fmt.Sprintf("%02X%02X%02X", c.R, c.G, c.B),
+			Count:      count,
+			Percentage: float64(count) / float64(total) * 100,
+		})
+	}
+	sortBOM(bom)
+	return bom
+}
+
+// sortBOM sorts the entries by count, the most used first, then by color ID and then by part ID.
+func sortBOM(bom []BOMEntry) {
+	sort.SliceStable(bom, func(i, j int) bool {
+		switch {
+		case bom[i].Count != bom[j].Count:
+			return bom[i].Count > bom[j].Count
+		case bom[i].LegoID != bom[j].LegoID:
+			return bom[i].LegoID < bom[j].LegoID
+		}
+		return bom[i].PartID < bom[j].PartID
+	})
+}
+
+// writeBOMCSV writes the bill of materials as a CSV file with the header part,legoid,name,hex,count,percentage.
+func writeBOMCSV(w io.Writer, bom []BOMEntry) error {
+	csvWriter := csv.NewWriter(w)
+	_ = csvWriter.Write([]string{"part", "legoid", "name", "hex", "count", "percentage"})
+	for _, e := range bom {
+		_ = csvWriter.Write([]string{
+			e.PartID,
+			strconv.Itoa(e.LegoID),
+			e.Name,
+			e.Hex,
+			strconv.Itoa(e.Count),
+			strconv.FormatFloat(e.Percentage, 'f', 2, 64),
+		})
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return fmt.Errorf("writing bill of materials: err=%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_billOfMaterials(t *testing.T) {
+	colors := []LegoColor{
+		{LegoID: 15, Name: "White", R: 255, G: 255, B: 255},
+		{LegoID: 4, Name: "Red", R: 201, G: 26, B: 9},
+		{LegoID: 0, Name: "Black", R: 5, G: 19, B: 29},
+		{LegoID: 1, Name: "Blue", R: 0, G: 85, B: 191},
+	}
+	grid := [][]int{
+		{1, 1, 0},
+		{2, 1, 0},
+		{2, 1, -1},
+	}
+	want := []BOMEntry{
+		{PartID: "3024", LegoID: 4, Name: "Red", Hex: "C91A09", Count: 4, Percentage: 50},
+		{PartID: "3024", LegoID: 0, Name: "Black", Hex: "05131D", Count: 2, Percentage: 25},
+		{PartID: "3024", LegoID: 15, Name: "White", Hex: "FFFFFF", Count: 2, Percentage: 25},
+	}
+	bom := billOfMaterials(colors, grid, "3024")
+	if !reflect.DeepEqual(bom, want) {
+		t.Errorf("wrong bill of materials: got=%+v, expected=%+v", bom, want)
+	}
+
+	var buf bytes.Buffer
+	if err := writeBOMCSV(&buf, bom); err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	wantCSV := "part,legoid,name,hex,count,percentage\n3024,4,Red,C91A09,4,50.00\n3024,0,Black,05131D,2,25.00\n3024,15,White,FFFFFF,2,25.00\n"
+	if buf.String() != wantCSV {
+		t.Errorf("wrong CSV: got=%q, expected=%q", buf.String(), wantCSV)
+	}
+}
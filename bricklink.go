@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ColorIDMap maps the Rebrickable color IDs, the ones used by LegoColor, to BrickLink color IDs.
+type ColorIDMap map[int]int
+
+// bricklinkColorIDs is the BrickLink ID of the Rebrickable colors, as listed on https://rebrickable.com/colors/.
+// Colors that BrickLink doesn't have, or whose ID isn't certain, are left out and can be added with an override file.
+var bricklinkColorIDs = ColorIDMap{
+	0: 11, 1: 7, 2: 6, 3: 39, 4: 5, 5: 47, 6: 8, 7: 9, 8: 10, 9: 62,
+	10: 36, 11: 40, 12: 25, 13: 23, 14: 3, 15: 1, 17: 38, 18: 33, 19: 2,
+	20: 44, 21: 46, 22: 24, 23: 109, 25: 4, 26: 71, 27: 34, 28: 69, 29: 104,
+	30: 157, 31: 154, 33: 14, 34: 20, 35: 108, 36: 17,
+	40: 13, 41: 15, 42: 16, 43: 113, 45: 50, 46: 19, 47: 12,
+	52: 51, 54: 121, 57: 18,
+	60: 57, 61: 52, 62: 64, 63: 82, 68: 96, 69: 93,
+	70: 88, 71: 86, 72: 85, 73: 42, 74: 37, 75: 116, 76: 117, 77: 56, 78: 90, 79: 60,
+	80: 67, 81: 70, 82: 65, 84: 150, 85: 89, 86: 91,
+	92: 28, 100: 26, 110: 43, 112: 97, 114: 100, 115: 76, 117: 101, 118: 41,
+	120: 35, 125: 32, 129: 102, 132: 111, 134: 84, 135: 66, 137: 78,
+	142: 61, 143: 74, 148: 77, 150: 119, 151: 99, 158: 158,
+	178: 81, 179: 95, 182: 98, 183: 83, 191: 110,
+	212: 105, 216: 27, 226: 103, 230: 107, 232: 87, 236: 114,
+	272: 63, 288: 80, 294: 118, 297: 115,
+	308: 120, 313: 72, 320: 59, 321: 153, 322: 156, 323: 152, 326: 155,
+	334: 21, 335: 58, 351: 94, 366: 29, 373: 54, 378: 48, 379: 55, 383: 22,
+	450: 106, 462: 31, 484: 68, 503: 49,
+	1000: 159, 1001: 73, 1002: 163, 1003: 162, 1012: 160, 1050: 220, 1052: 222,
+}
+
+// defaultColorIDMap returns a copy of the built-in Rebrickable to BrickLink color table.
+func defaultColorIDMap() ColorIDMap {
+	m := make(ColorIDMap, len(bricklinkColorIDs))
+	for rebrickable, bricklink := range bricklinkColorIDs {
+		m[rebrickable] = bricklink
+	}
+	return m
+}
+
+// rebrickableIDs returns the inverse table, from BrickLink to Rebrickable IDs. When several
+// Rebrickable colors have the same BrickLink ID the lowest Rebrickable ID is used.
+func (m ColorIDMap) rebrickableIDs() map[int]int {
+	ids := make([]int, 0, len(m))
+	for rebrickable := range m {
+		ids = append(ids, rebrickable)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+
+	inverse := make(map[int]int, len(m))
+	for _, rebrickable := range ids {
+		inverse[m[rebrickable]] = rebrickable
+	}
+	return inverse
+}
+
+// addFromCSV reads a CSV file with the header rebrickable,bricklink and adds its
+// colors to the table, replacing the ones already in it.
+func (m ColorIDMap) addFromCSV(f io.Reader) error {
+	csvReader := csv.NewReader(f)
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return fmt.Errorf("the CSV file is empty")
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse file as CSV: err=%v", err)
+	}
+
+	rebrickableColumn, bricklinkColumn := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "rebrickable":
+			rebrickableColumn = i
+		case "bricklink":
+			bricklinkColumn = i
+		}
+	}
+	if rebrickableColumn < 0 || bricklinkColumn < 0 {
+		return fmt.Errorf("unknown CSV header, expected rebrickable,bricklink: header=%q", strings.Join(header, ","))
+	}
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse file as CSV: err=%v", err)
+		}
+		line, _ := csvReader.FieldPos(0)
+
+		rebrickable, err := strconv.Atoi(strings.TrimSpace(record[rebrickableColumn]))
+		if err != nil {
+			return fmt.Errorf("line %d: invalid rebrickable: rebrickable=%q", line, record[rebrickableColumn])
+		}
+		bricklink, err := strconv.Atoi(strings.TrimSpace(record[bricklinkColumn]))
+		if err != nil {
+			return fmt.Errorf("line %d: invalid bricklink: bricklink=%q", line, record[bricklinkColumn])
+		}
+		m[rebrickable] = bricklink
+	}
+	return nil
+}
+
+// bricklinkInventory is the XML format BrickLink uses for wanted lists and store inventories.
+type bricklinkInventory struct {
+	XMLName xml.Name        `xml:"INVENTORY"`
+	Items   []bricklinkItem `xml:"ITEM"`
+}
+
+type bricklinkItem struct {
+	ItemType string `xml:"ITEMTYPE"`
+	ItemID   string `xml:"ITEMID"`
+	Color    int    `xml:"COLOR"`
+	// Qty is set in store inventories and MinQty in wanted lists
+	Qty    int `xml:"QTY,omitempty"`
+	MinQty int `xml:"MINQTY,omitempty"`
+}
+
+// stockFromBrickLinkXML reads the pieces of the given parts from a BrickLink XML file, translating
+// the BrickLink color IDs to Rebrickable ones. The BrickLink IDs of the colors that aren't in the
+// table are returned, sorted, and their pieces left out of the stock.
+func stockFromBrickLinkXML(f io.Reader, parts []string, colors ColorIDMap) (Stock, []int, error) {
+	var inventory bricklinkInventory
+	if err := xml.NewDecoder(f).Decode(&inventory); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse file as BrickLink XML: err=%v", err)
+	}
+
+	rebrickableIDs := colors.rebrickableIDs()
+	stock := make(Stock)
+	unknown := make(map[int]bool)
+	for _, item := range inventory.Items {
+		if item.ItemType != "P" || !isPart(parts, item.ItemID) {
+			continue
+		}
+		quantity := item.Qty
+		if quantity == 0 {
+			quantity = item.MinQty
+		}
+		if quantity < 0 {
+			return nil, nil, fmt.Errorf("invalid quantity: item=%s, color=%d, quantity=%d", item.ItemID, item.Color, quantity)
+		}
+
+		id, ok := rebrickableIDs[item.Color]
+		if !ok {
+			unknown[item.Color] = true
+			continue
+		}
+		stock[id] += quantity
+	}
+
+	unknownIDs := make([]int, 0, len(unknown))
+	for id := range unknown {
+		unknownIDs = append(unknownIDs, id)
+	}
+	sort.Ints(unknownIDs)
+	return stock, unknownIDs, nil
+}
+
+// writeBrickLinkXML writes the bill of materials as a BrickLink wanted list, adding the spare
+// pieces. The entries whose color has no BrickLink ID are left out and returned.
+func writeBrickLinkXML(w io.Writer, bom []BOMEntry, sparePercent float64, colors ColorIDMap) ([]BOMEntry, error) {
+	inventory := bricklinkInventory{}
+	var missing []BOMEntry
+	for _, e := range bom {
+		id, ok := colors[e.LegoID]
+		if !ok {
+			missing = append(missing, e)
+			continue
+		}
+		inventory.Items = append(inventory.Items, bricklinkItem{
+			ItemType: "P",
+			ItemID:   e.PartID,
+			Color:    id,
+			MinQty:   withSpares(e.Count, sparePercent),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, fmt.Errorf("writing BrickLink XML: err=%v", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(inventory); err != nil {
+		return nil, fmt.Errorf("writing BrickLink XML: err=%v", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return nil, fmt.Errorf("writing BrickLink XML: err=%v", err)
+	}
+	return missing, nil
+}
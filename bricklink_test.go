@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testBrickLinkXML = `<?xml version="1.0" encoding="UTF-8"?>
+<INVENTORY>
+<ITEM><ITEMTYPE>P</ITEMTYPE><ITEMID>3024</ITEMID><COLOR>11</COLOR><QTY>120</QTY></ITEM>
+<ITEM><ITEMTYPE>P</ITEMTYPE><ITEMID>3024</ITEMID><COLOR>86</COLOR><QTY>30</QTY></ITEM>
+<ITEM><ITEMTYPE>P</ITEMTYPE><ITEMID>98138</ITEMID><COLOR>11</COLOR><MINQTY>5</MINQTY></ITEM>
+<ITEM><ITEMTYPE>P</ITEMTYPE><ITEMID>3001</ITEMID><COLOR>1</COLOR><QTY>50</QTY></ITEM>
+<ITEM><ITEMTYPE>S</ITEMTYPE><ITEMID>3024</ITEMID><COLOR>1</COLOR><QTY>1</QTY></ITEM>
+<ITEM><ITEMTYPE>P</ITEMTYPE><ITEMID>3024</ITEMID><COLOR>9999</COLOR><QTY>8</QTY></ITEM>
+</INVENTORY>`
+
+func Test_stockFromBrickLinkXML(t *testing.T) {
+	stock, unknown, err := stockFromBrickLinkXML(strings.NewReader(testBrickLinkXML), []string{"3024", "98138"}, defaultColorIDMap())
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	if want := (Stock{0: 125, 71: 30}); !reflect.DeepEqual(stock, want) {
+		t.Errorf("wrong stock: got=%v, expected=%v", stock, want)
+	}
+	if want := []int{9999}; !reflect.DeepEqual(unknown, want) {
+		t.Errorf("wrong unknown colors: got=%v, expected=%v", unknown, want)
+	}
+
+	if _, _, err := stockFromBrickLinkXML(strings.NewReader("<INVENTORY><ITEM>"), []string{"3024", "98138"}, defaultColorIDMap()); err == nil {
+		t.Errorf("invalid XML should return an error")
+	}
+}
+
+func Test_loadStock(t *testing.T) {
+	stock, _, err := loadStock(strings.NewReader("  "+testBrickLinkXML), []string{"98138"}, defaultColorIDMap())
+	if err != nil || !reflect.DeepEqual(stock, Stock{0: 5}) {
+		t.Errorf("wrong stock from XML: stock=%v, err=%v", stock, err)
+	}
+	stock, _, err = loadStock(strings.NewReader("color,quantity\n4,10\n"), []string{"3024", "98138"}, defaultColorIDMap())
+	if err != nil || !reflect.DeepEqual(stock, Stock{4: 10}) {
+		t.Errorf("wrong stock from CSV: stock=%v, err=%v", stock, err)
+	}
+}
+
+func TestColorIDMapAddFromCSV(t *testing.T) {
+	colors := defaultColorIDMap()
+	if err := colors.addFromCSV(strings.NewReader("rebrickable,bricklink\n1062,248\n0,999\n")); err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	if colors[1062] != 248 || colors[0] != 999 {
+		t.Errorf("wrong color table: 1062=%d, 0=%d", colors[1062], colors[0])
+	}
+	if bricklinkColorIDs[0] != 11 {
+		t.Errorf("the built-in color table shouldn't change")
+	}
+	if err := colors.addFromCSV(strings.NewReader("rebrickable,bricklink\nblack,11\n")); err == nil {
+		t.Errorf("invalid IDs should return an error")
+	}
+}
+
+func TestBricklinkColorIDsUnique(t *testing.T) {
+	seen := make(map[int]int)
+	for rebrickable, bricklink := range bricklinkColorIDs {
+		if other, ok := seen[bricklink]; ok {
+			t.Errorf("BrickLink color used twice: bricklink=%d, rebrickable=%d and %d", bricklink, rebrickable, other)
+		}
+		seen[bricklink] = rebrickable
+	}
+}
+
+func Test_writeBrickLinkXML(t *testing.T) {
+	bom := []BOMEntry{
+		{PartID: "3024", LegoID: 0, Name: "Black", Count: 100},
+		{PartID: "3024", LegoID: 1054, Name: "Trans-Medium Reddish Violet Opal", Count: 3},
+		{PartID: "3024", LegoID: 71, Name: "Light Bluish Gray", Count: 7},
+	}
+	var buf strings.Builder
+	missing, err := writeBrickLinkXML(&buf, bom, 10, defaultColorIDMap())
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	if len(missing) != 1 || missing[0].LegoID != 1054 {
+		t.Errorf("wrong missing colors: got=%+v", missing)
+	}
+	if !strings.Contains(buf.String(), "<ITEM>\n    <ITEMTYPE>P</ITEMTYPE>\n    <ITEMID>3024</ITEMID>\n    <COLOR>11</COLOR>\n    <MINQTY>110</MINQTY>\n  </ITEM>") {
+		t.Errorf("wrong BrickLink XML: got=%s", buf.String())
+	}
+
+	// the wanted list can be read back as a stock
+	stock, _, err := stockFromBrickLinkXML(strings.NewReader(buf.String()), []string{"3024", "98138"}, defaultColorIDMap())
+	if want := (Stock{0: 110, 71: 8}); err != nil || !reflect.DeepEqual(stock, want) {
+		t.Errorf("wrong stock read back: got=%v, expected=%v, err=%v", stock, want, err)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import "math"
+
+// Lab is a color in the CIELAB space, using the D65 reference white.
+type Lab struct {
+	L float64
+	A float64
+	B float64
+}
+
+// D65 reference white, as used by sRGB.
+const (
+	whiteX = 0.95047
+	whiteY = 1.00000
+	whiteZ = 1.08883
+)
+
+// srgbToLinear holds the linearized value of every 8 bit sRGB channel value,
+// so the conversion doesn't pay for a math.Pow per channel and pixel.
+var srgbToLinear [256]float64
+
+func init() {
+	for i := range srgbToLinear {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			srgbToLinear[i] = c / 12.92
+		} else {
+			srgbToLinear[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+}
+
+// labFromRGB converts an 8 bit sRGB color into CIELAB.
+// Reference: http://www.brucelindbloom.com/index.html?Eqn_RGB_to_XYZ.html
+func labFromRGB(r, g, b int) Lab {
+	rl, gl, bl := srgbToLinear[clamp8(r)], srgbToLinear[clamp8(g)], srgbToLinear[clamp8(b)]
+
+	x := (0.4124564*rl + 0.3575761*gl + 0.1804375*bl) / whiteX
+	y := (0.2126729*rl + 0.7151522*gl + 0.0721750*bl) / whiteY
+	z := (0.0193339*rl + 0.1191920*gl + 0.9503041*bl) / whiteZ
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+
+	return Lab{
+		L: 116*fy - 16,
+		A: 500 * (fx - fy),
+		B: 200 * (fy - fz),
+	}
+}
+
+func labF(t float64) float64 {
+	const epsilon = 216.0 / 24389.0
+	const kappa = 24389.0 / 27.0
+	if t > epsilon {
+		return math.Cbrt(t)
+	}
+	return (kappa*t + 16) / 116
+}
+
+func clamp8(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// deltaE76 is the CIE76 color difference: the euclidean distance in CIELAB.
+func deltaE76(c1, c2 Lab) float64 {
+	dL, dA, dB := c1.L-c2.L, c1.A-c2.A, c1.B-c2.B
+	return math.Sqrt(dL*dL + dA*dA + dB*dB)
+}
+
+// deltaE94 is the CIE94 color difference, with the graphic arts weights.
+// Note that CIE94 is not symmetric, c1 is taken as the reference color.
+func deltaE94(c1, c2 Lab) float64 {
+	const kL, k1, k2 = 1.0, 0.045, 0.015
+
+	dL := c1.L - c2.L
+	c1C := math.Hypot(c1.A, c1.B)
+	c2C := math.Hypot(c2.A, c2.B)
+	dC := c1C - c2C
+	dA, dB := c1.A-c2.A, c1.B-c2.B
+	dH2 := dA*dA + dB*dB - dC*dC
+	if dH2 < 0 {
+		dH2 = 0
+	}
+
+	sL := 1.0
+	sC := 1 + k1*c1C
+	sH := 1 + k2*c1C
+
+	l, c := dL/(kL*sL), dC/sC
+	return math.Sqrt(l*l + c*c + dH2/(sH*sH))
+}
+
+// deltaE2000 is the CIEDE2000 color difference.
+// Reference: Sharma, Wu, Dalal, "The CIEDE2000 Color-Difference Formula:
+// Implementation Notes, Supplementary Test Data, and Mathematical Observations" (2005).
+func deltaE2000(c1, c2 Lab) float64 {
+	const kL, kC, kH = 1.0, 1.0, 1.0
+	const pow25to7 = 6103515625.0 // 25^7
+
+	cBar := (math.Hypot(c1.A, c1.B) + math.Hypot(c2.A, c2.B)) / 2
+	cBar7 := math.Pow(cBar, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+pow25to7)))
+
+	a1p, a2p := (1+g)*c1.A, (1+g)*c2.A
+	c1p, c2p := math.Hypot(a1p, c1.B), math.Hypot(a2p, c2.B)
+	h1p, h2p := hueAngle(c1.B, a1p), hueAngle(c2.B, a2p)
+
+	dLp := c2.L - c1.L
+	dCp := c2p - c1p
+
+	var dhp float64
+	switch {
+	case c1p*c2p == 0:
+		dhp = 0
+	case math.Abs(h2p-h1p) <= 180:
+		dhp = h2p - h1p
+	case h2p-h1p > 180:
+		dhp = h2p - h1p - 360
+	default:
+		dhp = h2p - h1p + 360
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(radians(dhp/2))
+
+	lBarp := (c1.L + c2.L) / 2
+	cBarp := (c1p + c2p) / 2
+
+	var hBarp float64
+	switch {
+	case c1p*c2p == 0:
+		hBarp = h1p + h2p
+	case math.Abs(h1p-h2p) <= 180:
+		hBarp = (h1p + h2p) / 2
+	case h1p+h2p < 360:
+		hBarp = (h1p + h2p + 360) / 2
+	default:
+		hBarp = (h1p + h2p - 360) / 2
+	}
+
+	t := 1 - 0.17*math.Cos(radians(hBarp-30)) +
+		0.24*math.Cos(radians(2*hBarp)) +
+		0.32*math.Cos(radians(3*hBarp+6)) -
+		0.20*math.Cos(radians(4*hBarp-63))
+
+	dTheta := 30 * math.Exp(-math.Pow((hBarp-275)/25, 2))
+	cBarp7 := math.Pow(cBarp, 7)
+	rC := 2 * math.Sqrt(cBarp7/(cBarp7+pow25to7))
+	lBarp50 := (lBarp - 50) * (lBarp - 50)
+	sL := 1 + 0.015*lBarp50/math.Sqrt(20+lBarp50)
+	sC := 1 + 0.045*cBarp
+	sH := 1 + 0.015*cBarp*t
+	rT := -math.Sin(radians(2*dTheta)) * rC
+
+	l := dLp / (kL * sL)
+	c := dCp / (kC * sC)
+	h := dHp / (kH * sH)
+
+	return math.Sqrt(l*l + c*c + h*h + rT*c*h)
+}
+
+// hueAngle returns the angle of (a, b) in degrees, in the range [0, 360).
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func Test_labFromRGB(t *testing.T) {
+	tests := []struct {
+		name    string
+		r, g, b int
+		want    Lab
+	}{
+		{name: "black", r: 0, g: 0, b: 0, want: Lab{L: 0, A: 0, B: 0}},
+		{name: "white", r: 255, g: 255, b: 255, want: Lab{L: 100, A: 0, B: 0}},
+		{name: "red", r: 255, g: 0, b: 0, want: Lab{L: 53.2408, A: 80.0925, B: 67.2032}},
+		{name: "blue", r: 0, g: 0, b: 255, want: Lab{L: 32.2970, A: 79.1875, B: -107.8602}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := labFromRGB(tt.r, tt.g, tt.b)
+			if math.Abs(got.L-tt.want.L) > 0.01 || math.Abs(got.A-tt.want.A) > 0.01 || math.Abs(got.B-tt.want.B) > 0.01 {
+				t.Errorf("wrong lab conversion: got=%+v, expected=%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test pairs taken from Sharma, Wu, Dalal (2005), table 1.
+func Test_deltaE2000(t *testing.T) {
+	tests := []struct {
+		c1, c2 Lab
+		want   float64
+	}{
+		{c1: Lab{50.0000, 2.6772, -79.7751}, c2: Lab{50.0000, 0.0000, -82.7485}, want: 2.0425},
+		{c1: Lab{50.0000, 3.1571, -77.2803}, c2: Lab{50.0000, 0.0000, -82.7485}, want: 2.8615},
+		{c1: Lab{50.0000, 0.0000, 0.0000}, c2: Lab{50.0000, -1.0000, 2.0000}, want: 2.3669},
+		{c1: Lab{50.0000, 2.5000, 0.0000}, c2: Lab{73.0000, 25.0000, -18.0000}, want: 27.1492},
+		{c1: Lab{60.2574, -34.0099, 36.2677}, c2: Lab{60.4626, -34.1751, 39.4387}, want: 1.2644},
+		{c1: Lab{22.7233, 20.0904, -46.6940}, c2: Lab{23.0331, 14.9730, -42.5619}, want: 2.0373},
+	}
+	for _, tt := range tests {
+		if got := deltaE2000(tt.c1, tt.c2); math.Abs(got-tt.want) > 0.0001 {
+			t.Errorf("wrong CIEDE2000 distance: c1=%+v, c2=%+v, got=%.4f, expected=%.4f", tt.c1, tt.c2, got, tt.want)
+		}
+		if got := deltaE2000(tt.c2, tt.c1); math.Abs(got-tt.want) > 0.0001 {
+			t.Errorf("CIEDE2000 should be symmetric: c1=%+v, c2=%+v, got=%.4f, expected=%.4f", tt.c2, tt.c1, got, tt.want)
+		}
+	}
+}
+
+// Test pairs taken from Sharma, Wu, Dalal (2005), table 1, with the CIE76 and CIE94 (graphic arts)
+// differences of the first color to the second one.
+var deltaETests = []struct {
+	c1, c2     Lab
+	want76     float64
+	want94     float64
+	want94Swap float64
+}{
+	{c1: Lab{50.0000, 2.6772, -79.7751}, c2: Lab{50.0000, 0.0000, -82.7485}, want76: 4.0011, want94: 1.3950, want94Swap: 1.3653},
+	{c1: Lab{50.0000, 0.0000, 0.0000}, c2: Lab{50.0000, -1.0000, 2.0000}, want76: 2.2361, want94: 2.2361, want94Swap: 2.0316},
+	{c1: Lab{50.0000, 2.5000, 0.0000}, c2: Lab{73.0000, 25.0000, -18.0000}, want76: 36.8680, want94: 34.6892, want94Swap: 26.1398},
+	{c1: Lab{60.2574, -34.0099, 36.2677}, c2: Lab{60.4626, -34.1751, 39.4387}, want76: 3.1819, want94: 1.3910, want94Swap: 1.3576},
+	{c1: Lab{22.7233, 20.0904, -46.6940}, c2: Lab{23.0331, 14.9730, -42.5619}, want76: 6.5847, want94: 2.5561, want94Swap: 2.7251},
+}
+
+func Test_deltaE76(t *testing.T) {
+	for _, tt := range deltaETests {
+		if got := deltaE76(tt.c1, tt.c2); math.Abs(got-tt.want76) > 0.0001 {
+			t.Errorf("wrong CIE76 distance: c1=%+v, c2=%+v, got=%.4f, expected=%.4f", tt.c1, tt.c2, got, tt.want76)
+		}
+		if got := deltaE76(tt.c2, tt.c1); math.Abs(got-tt.want76) > 0.0001 {
+			t.Errorf("CIE76 should be symmetric: c1=%+v, c2=%+v, got=%.4f, expected=%.4f", tt.c2, tt.c1, got, tt.want76)
+		}
+	}
+}
+
+func Test_deltaE94(t *testing.T) {
+	for _, tt := range deltaETests {
+		if got := deltaE94(tt.c1, tt.c2); math.Abs(got-tt.want94) > 0.0001 {
+			t.Errorf("wrong CIE94 distance: c1=%+v, c2=%+v, got=%.4f, expected=%.4f", tt.c1, tt.c2, got, tt.want94)
+		}
+		// the first color is the reference one
+		if got := deltaE94(tt.c2, tt.c1); math.Abs(got-tt.want94Swap) > 0.0001 {
+			t.Errorf("wrong CIE94 distance: c1=%+v, c2=%+v, got=%.4f, expected=%.4f", tt.c2, tt.c1, got, tt.want94Swap)
+		}
+	}
+}
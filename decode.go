@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	// decoders registered for image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// loadImage decodes an image in any of the supported formats (PNG, JPEG, GIF, BMP, TIFF and WebP),
+// detected from its content. It returns the name of the format found, e.g. "jpeg".
+//
+// Unless ignoreEXIF is set, the image is rotated and flipped as its EXIF orientation says,
+// so photos taken with the camera sideways come out upright.
+func loadImage(r io.Reader, ignoreEXIF bool) (image.Image, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading image: err=%v", err)
+	}
+
+	source, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding image: err=%v", err)
+	}
+
+	if !ignoreEXIF {
+		source = orientImage(source, exifOrientation(data))
+	}
+	return source, format, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+func Test_loadImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 3; y++ {
+			img.SetRGBA(x, y, color.RGBA{R: 200, G: 30, B: 10, A: 255})
+		}
+	}
+
+	// 1x1 lossless WebP, there is no WebP encoder available
+	webp, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+
+	tests := []struct {
+		format string
+		encode func(w io.Writer) error
+		size   image.Point
+	}{
+		{format: "png", encode: func(w io.Writer) error { return png.Encode(w, img) }, size: image.Pt(4, 3)},
+		{format: "jpeg", encode: func(w io.Writer) error { return jpeg.Encode(w, img, nil) }, size: image.Pt(4, 3)},
+		{format: "gif", encode: func(w io.Writer) error { return gif.Encode(w, img, nil) }, size: image.Pt(4, 3)},
+		{format: "bmp", encode: func(w io.Writer) error { return bmp.Encode(w, img) }, size: image.Pt(4, 3)},
+		{format: "tiff", encode: func(w io.Writer) error { return tiff.Encode(w, img, nil) }, size: image.Pt(4, 3)},
+		{format: "webp", encode: func(w io.Writer) error { _, err := w.Write(webp); return err }, size: image.Pt(1, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.encode(&buf); err != nil {
+				t.Fatalf("encoding image: err=%v", err)
+			}
+
+			source, format, err := loadImage(&buf, false)
+			if err != nil {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if format != tt.format || source.Bounds().Size() != tt.size {
+				t.Errorf("wrong image decoded: format=%s, size=%v, expected format=%s, size=%v", format, source.Bounds().Size(), tt.format, tt.size)
+			}
+		})
+	}
+
+	if _, _, err := loadImage(bytes.NewReader([]byte("not an image")), false); err == nil {
+		t.Errorf("an unknown format should return an error")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Supported dithering modes.
+const (
+	ditherNone           = "none"
+	ditherFloydSteinberg = "floyd-steinberg"
+	ditherJJN            = "jarvis-judice-ninke"
+	ditherStucki         = "stucki"
+	ditherAtkinson       = "atkinson"
+	ditherSierra         = "sierra"
+	ditherBayer2         = "bayer2"
+	ditherBayer4         = "bayer4"
+	ditherBayer8         = "bayer8"
+	ditherBlueNoise      = "blue-noise"
+)
+
+// Dithering configures how the quantization error of a pixel is spread over its neighbours,
+// either diffusing it or, for the ordered modes, offsetting every pixel by a threshold map.
+type Dithering struct {
+	// Mode is the name of the dithering algorithm, no dithering is applied when empty.
+	Mode string
+	// Strength scales the error propagated to the neighbours, 1 propagates all of it.
+	// For the ordered modes it scales the amplitude of the threshold map.
+	Strength float64
+	// Serpentine alternates the scanning direction on every row, which avoids the
+	// directional artifacts of always scanning left to right.
+	Serpentine bool
+}
+
+// diffusionWeight is the share of the error that goes to the pixel at (dx, dy),
+// relative to the current pixel and assuming a left to right scan.
+type diffusionWeight struct {
+	dx, dy int
+	weight float64
+}
+
+// Error diffusion kernels.
+// Reference: https://tannerhelland.com/2012/12/28/dithering-eleven-algorithms-source-code.html
+var diffusionKernels = map[string][]diffusionWeight{
+	ditherFloydSteinberg: {
+		{1, 0, 7.0 / 16},
+		{-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	ditherJJN: {
+		{1, 0, 7.0 / 48}, {2, 0, 5.0 / 48},
+		{-2, 1, 3.0 / 48}, {-1, 1, 5.0 / 48}, {0, 1, 7.0 / 48}, {1, 1, 5.0 / 48}, {2, 1, 3.0 / 48},
+		{-2, 2, 1.0 / 48}, {-1, 2, 3.0 / 48}, {0, 2, 5.0 / 48}, {1, 2, 3.0 / 48}, {2, 2, 1.0 / 48},
+	},
+	ditherStucki: {
+		{1, 0, 8.0 / 42}, {2, 0, 4.0 / 42},
+		{-2, 1, 2.0 / 42}, {-1, 1, 4.0 / 42}, {0, 1, 8.0 / 42}, {1, 1, 4.0 / 42}, {2, 1, 2.0 / 42},
+		{-2, 2, 1.0 / 42}, {-1, 2, 2.0 / 42}, {0, 2, 4.0 / 42}, {1, 2, 2.0 / 42}, {2, 2, 1.0 / 42},
+	},
+	// Atkinson only propagates 6/8 of the error, which keeps more contrast.
+	ditherAtkinson: {
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8},
+		{-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8},
+		{0, 2, 1.0 / 8},
+	},
+	ditherSierra: {
+		{1, 0, 5.0 / 32}, {2, 0, 3.0 / 32},
+		{-2, 1, 2.0 / 32}, {-1, 1, 4.0 / 32}, {0, 1, 5.0 / 32}, {1, 1, 4.0 / 32}, {2, 1, 2.0 / 32},
+		{-1, 2, 2.0 / 32}, {0, 2, 3.0 / 32}, {1, 2, 2.0 / 32},
+	},
+}
+
+// DitherModes returns the names of the supported dithering modes.
+func DitherModes() []string {
+	return []string{
+		ditherNone, ditherFloydSteinberg, ditherJJN, ditherStucki, ditherAtkinson, ditherSierra,
+		ditherBayer2, ditherBayer4, ditherBayer8, ditherBlueNoise,
+	}
+}
+
+// thresholdMap returns the threshold map of an ordered dithering mode, with values in [0, 1).
+func thresholdMap(mode string) ([][]float64, bool) {
+	switch mode {
+	case ditherBayer2:
+		return bayerMatrix(2), true
+	case ditherBayer4:
+		return bayerMatrix(4), true
+	case ditherBayer8:
+		return bayerMatrix(8), true
+	case ditherBlueNoise:
+		return blueNoiseMatrix(), true
+	}
+	return nil, false
+}
+
+func (d Dithering) validate() error {
+	if d.Mode == "" || d.Mode == ditherNone {
+		return nil
+	}
+	_, diffusion := diffusionKernels[d.Mode]
+	_, ordered := thresholdMap(d.Mode)
+	if !diffusion && !ordered {
+		return fmt.Errorf("unknown dithering mode: mode=%q", d.Mode)
+	}
+	return nil
+}
+
+// diffuse quantizes the image into grid spreading the error of every pixel over the pixels not
+// visited yet, in the metric's color space. It returns the number of rows completed.
+//
+// Instead of pushing the error forward, every pixel pulls the error of the already quantized
+// pixels that the kernel spreads onto it. Both are equivalent, but pulling adds up the
+// contributions always in the same order, so the result doesn't depend on the scheduling.
+// Rows are processed in parallel as a wavefront, every row staying behind the one above it.
+// Scanning in serpentine requires the row above to be complete before starting the next one,
+// so in that case the rows are effectively processed one after the other.
+func diffuse(ctx context.Context, img *image.RGBA, m *colorMatcher, d Dithering, grid [][]int, workers int) (int, error) {
+	kernel := diffusionKernels[d.Mode]
+	var reach int
+	for _, k := range kernel {
+		if k.dx > reach {
+			reach = k.dx
+		} else if -k.dx > reach {
+			reach = -k.dx
+		}
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	errs := make([][]ColorVec, height)
+	for y := range errs {
+		errs[y] = make([]ColorVec, width)
+	}
+
+	w := newWavefront(height)
+	return w.run(ctx, workers, func(y int) {
+		dir := d.direction(y)
+		for i := 0; i < width; i++ {
+			x := i
+			if dir < 0 {
+				x = width - 1 - i
+			}
+
+			// the row above must have processed the columns [x-reach, x+reach]
+			if y > 0 && !w.wait(ctx, y-1, d.processedBefore(y-1, x, reach, width)) {
+				return
+			}
+
+			var incoming ColorVec
+			for _, k := range kernel {
+				sy := y - k.dy
+				if sy < 0 {
+					continue
+				}
+				// the kernel is mirrored on the rows scanned right to left
+				sx := x - k.dx*d.direction(sy)
+				if sx < 0 || sx >= width {
+					continue
+				}
+				for c := range incoming {
+					incoming[c] += errs[sy][sx][c] * k.weight
+				}
+			}
+
+			p := img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			target := m.metric.Project(Pixel{R: int(p.R), G: int(p.G), B: int(p.B)})
+			for c := range target {
+				target[c] += incoming[c] * d.Strength
+			}
+
+			best := m.nearest(target)
+			grid[x][y] = best
+			for c := range target {
+				errs[y][x][c] = target[c] - m.palette[best][c]
+			}
+
+			w.advance(y, i+1, i == width-1)
+		}
+	})
+}
+
+// processedBefore returns how many pixels of the row y must be processed for the columns
+// [x-reach, x+reach] to be done, taking into account the direction the row is scanned in.
+func (d Dithering) processedBefore(y, x, reach, width int) int {
+	if d.direction(y) > 0 {
+		if x+reach+1 > width {
+			return width
+		}
+		return x + reach + 1
+	}
+	if x-reach < 0 {
+		return width
+	}
+	return width - (x - reach)
+}
+
+// direction returns 1 when the row y is scanned left to right and -1 otherwise.
+func (d Dithering) direction(y int) int {
+	if d.Serpentine && y%2 == 1 {
+		return -1
+	}
+	return 1
+}
+
+// bayerMatrix returns the n x n Bayer threshold map, n being a power of two.
+// Every matrix is built from the previous one as [[4M, 4M+2], [4M+3, 4M+1]].
+func bayerMatrix(n int) [][]float64 {
+	m := [][]int{{0}}
+	for size := 1; size < n; size *= 2 {
+		next := make([][]int, size*2)
+		for y := range next {
+			next[y] = make([]int, size*2)
+			for x := range next[y] {
+				next[y][x] = 4*m[y%size][x%size] + [2][2]int{{0, 2}, {3, 1}}[y/size][x/size]
+			}
+		}
+		m = next
+	}
+
+	thresholds := make([][]float64, n)
+	for y := range m {
+		thresholds[y] = make([]float64, n)
+		for x := range m[y] {
+			thresholds[y][x] = (float64(m[y][x]) + 0.5) / float64(n*n)
+		}
+	}
+	return thresholds
+}
+
+// orderedPixel returns the palette index for the pixel at (x, y) offsetting it by the threshold
+// map tiled over the image, which results in regular patterns easy to follow by hand.
+func orderedPixel(img *image.RGBA, m *colorMatcher, d Dithering) func(x, y int) int {
+	pixelAt := orderedPixelAt(img, len(m.palette), d)
+	return func(x, y int) int {
+		return m.nearestPixel(pixelAt(x, y))
+	}
+}
+
+// orderedPixelAt returns the pixel at (x, y) offset by the threshold map. The offset is applied in
+// RGB and its amplitude is the expected distance between two neighbour palette colors, assuming
+// the palette is spread evenly over the RGB cube.
+func orderedPixelAt(img *image.RGBA, paletteSize int, d Dithering) func(x, y int) Pixel {
+	thresholds, _ := thresholdMap(d.Mode)
+	spread := 255 / math.Cbrt(float64(paletteSize)) * d.Strength
+	bounds := img.Bounds()
+
+	return func(x, y int) Pixel {
+		row := thresholds[y%len(thresholds)]
+		offset := int(math.Round((row[x%len(row)] - 0.5) * spread))
+
+		p := img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+		return Pixel{R: clamp8(int(p.R) + offset), G: clamp8(int(p.G) + offset), B: clamp8(int(p.B) + offset)}
+	}
+}
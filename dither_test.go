@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMapFromImageDiffusion(t *testing.T) {
+	colors := []LegoColor{
+		{LegoID: 0, Name: "Black", R: 0, G: 0, B: 0},
+		{LegoID: 15, Name: "White", R: 255, G: 255, B: 255},
+	}
+	// a mid gray in the weighted RGB space, so half of the pixels should end up white
+	img := newUniformRGBA(32, 32, Pixel{R: 128, G: 128, B: 128})
+
+	for mode := range diffusionKernels {
+		t.Run(mode, func(t *testing.T) {
+			lego := Lego{colors: colors, dither: Dithering{Mode: mode, Strength: 1, Serpentine: true}}
+			conversion, err := lego.mapFromImage(context.Background(), img)
+			if err != nil {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+
+			var white int
+			for x := 0; x < 32; x++ {
+				for y := 0; y < 32; y++ {
+					if conversion.Image.RGBAAt(x, y).R == 255 {
+						white++
+					}
+				}
+			}
+			// Atkinson drops a quarter of the error, so it is allowed to drift further
+			if white < 32*32*35/100 || white > 32*32*65/100 {
+				t.Errorf("dithering should mix black and white evenly: white=%d, total=%d", white, 32*32)
+			}
+		})
+	}
+
+	lego := Lego{colors: colors, dither: Dithering{Mode: "unknown"}}
+	if _, err := lego.mapFromImage(context.Background(), img); err == nil {
+		t.Errorf("an unknown dithering mode should return an error")
+	}
+}
+
+func Test_thresholdMap(t *testing.T) {
+	for _, mode := range []string{ditherBayer2, ditherBayer4, ditherBayer8, ditherBlueNoise} {
+		t.Run(mode, func(t *testing.T) {
+			thresholds, ok := thresholdMap(mode)
+			if !ok {
+				t.Fatalf("missing threshold map")
+			}
+
+			// every threshold should appear exactly once
+			n := len(thresholds) * len(thresholds)
+			seen := make(map[int]bool, n)
+			for _, row := range thresholds {
+				for _, v := range row {
+					rank := int(v * float64(n))
+					if v <= 0 || v >= 1 || seen[rank] {
+						t.Fatalf("thresholds should be a permutation in (0, 1): value=%f", v)
+					}
+					seen[rank] = true
+				}
+			}
+		})
+	}
+
+	want := [][]float64{{0.125, 0.625}, {0.875, 0.375}}
+	if got := bayerMatrix(2); got[0][0] != want[0][0] || got[0][1] != want[0][1] || got[1][0] != want[1][0] || got[1][1] != want[1][1] {
+		t.Errorf("wrong bayer matrix: got=%v, expected=%v", got, want)
+	}
+}
+
+func TestMapFromImageOrdered(t *testing.T) {
+	colors := []LegoColor{
+		{LegoID: 0, Name: "Black", R: 0, G: 0, B: 0},
+		{LegoID: 15, Name: "White", R: 255, G: 255, B: 255},
+	}
+	img := newUniformRGBA(8, 8, Pixel{R: 128, G: 128, B: 128})
+
+	lego := Lego{colors: colors, dither: Dithering{Mode: ditherBayer4, Strength: 1}}
+	conversion, err := lego.mapFromImage(context.Background(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+
+	// the pattern should repeat every 4 studs
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			if conversion.Image.RGBAAt(x, y) != conversion.Image.RGBAAt(x+4, y+4) {
+				t.Errorf("ordered dithering should produce a repeating pattern: x=%d, y=%d", x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// exifOrientationTag is the EXIF tag holding how the image has to be rotated or flipped to be displayed.
+const exifOrientationTag = 0x0112
+
+// exifOrientation returns the EXIF orientation of an encoded JPEG, PNG, WebP or TIFF image,
+// from 1 to 8. It returns 1, the image is displayed as stored, when there is no orientation.
+func exifOrientation(data []byte) int {
+	var tiff []byte
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		tiff = jpegEXIF(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		tiff = pngEXIF(data)
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		tiff = webpEXIF(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")):
+		tiff = data
+	}
+
+	orientation := tiffOrientation(bytes.TrimPrefix(tiff, []byte("Exif\x00\x00")))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// jpegEXIF returns the content of the APP1 Exif segment of a JPEG file.
+func jpegEXIF(data []byte) []byte {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return nil
+		}
+		marker := data[i+1]
+		// start of scan, the metadata segments are all before it
+		if marker == 0xda {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// pngEXIF returns the content of the eXIf chunk of a PNG file.
+func pngEXIF(data []byte) []byte {
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			return nil
+		}
+		if string(data[i+4:i+8]) == "eXIf" {
+			return data[i+8 : i+8+length]
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// webpEXIF returns the content of the EXIF chunk of a WebP file.
+func webpEXIF(data []byte) []byte {
+	for i := 12; i+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if length < 0 || i+8+length > len(data) {
+			return nil
+		}
+		if string(data[i:i+4]) == "EXIF" {
+			return data[i+8 : i+8+length]
+		}
+		// chunks are padded to an even size
+		i += 8 + length + length%2
+	}
+	return nil
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of a TIFF structure, 0 if missing.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// the orientation is a single SHORT, stored in the first bytes of the value field
+		if order.Uint16(tiff[entry:]) == exifOrientationTag && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// orientImage rotates and flips the image as the EXIF orientation requires for it to be displayed upright.
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if orientation >= 5 {
+		// orientations from 5 to 8 swap the width and height
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Rect, src, b.Min, draw.Src)
+
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flipped horizontally
+				dx, dy = w-1-sx, sy
+			case 3: // rotated 180 degrees
+				dx, dy = w-1-sx, h-1-sy
+			case 4: // flipped vertically
+				dx, dy = sx, h-1-sy
+			case 5: // transposed
+				dx, dy = sy, sx
+			case 6: // needs to be rotated 90 degrees clockwise
+				dx, dy = h-1-sy, sx
+			case 7: // transversed
+				dx, dy = h-1-sy, w-1-sx
+			case 8: // needs to be rotated 90 degrees counterclockwise
+				dx, dy = sy, w-1-sx
+			}
+			dst.SetRGBA(dx, dy, rgba.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
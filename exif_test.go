@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"testing"
+)
+
+// Every fixture stores the same 24x16 picture, made of 3x2 blocks of 8x8 studs, with the
+// rotation or flip that its EXIF orientation undoes.
+func TestLoadImageEXIFOrientation(t *testing.T) {
+	blocks := [2][3]color.RGBA{
+		{{R: 255}, {G: 255}, {B: 255}},
+		{{R: 255, G: 255}, {}, {R: 255, G: 255, B: 255}},
+	}
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		t.Run(fmt.Sprintf("orientation %d", orientation), func(t *testing.T) {
+			f, err := os.Open(fmt.Sprintf("testdata/orientation_%d.jpg", orientation))
+			if err != nil {
+				t.Fatalf("opening fixture: err=%v", err)
+			}
+			defer f.Close()
+
+			img, format, err := loadImage(f, false)
+			if err != nil {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if format != "jpeg" || img.Bounds().Dx() != 24 || img.Bounds().Dy() != 16 {
+				t.Fatalf("wrong image loaded: format=%s, bounds=%v", format, img.Bounds())
+			}
+
+			for by, row := range blocks {
+				for bx, want := range row {
+					// compare the center of every block, JPEG blurs the edges
+					r, g, b, _ := img.At(bx*8+4, by*8+4).RGBA()
+					if absDiff(int(r>>8), int(want.R)) > 48 || absDiff(int(g>>8), int(want.G)) > 48 || absDiff(int(b>>8), int(want.B)) > 48 {
+						t.Errorf("wrong color: block=(%d, %d), got=(%d, %d, %d), expected=%v", bx, by, r>>8, g>>8, b>>8, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLoadImageIgnoreEXIF(t *testing.T) {
+	f, err := os.Open("testdata/orientation_6.jpg")
+	if err != nil {
+		t.Fatalf("opening fixture: err=%v", err)
+	}
+	defer f.Close()
+
+	img, _, err := loadImage(f, true)
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 24 {
+		t.Errorf("the image should be kept as stored: bounds=%v", img.Bounds())
+	}
+}
+
+func absDiff(a, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
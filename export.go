@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+)
+
+// Supported formats of the parts lists exported with the conversion.
+const (
+	exportBrickLink   = "bricklink"
+	exportRebrickable = "rebrickable"
+)
+
+// ExportFormats returns the names of the supported export formats.
+func ExportFormats() []string {
+	return []string{exportBrickLink, exportRebrickable}
+}
+
+// ExportOptions describes the parts lists written along with the conversion.
+type ExportOptions struct {
+	Formats []string
+	// SparePercent is the share of pieces, in percent, added as spares to every color
+	SparePercent float64
+	// ColorIDs translates the color IDs to BrickLink ones
+	ColorIDs ColorIDMap
+}
+
+func (o ExportOptions) validate() error {
+	for _, format := range o.Formats {
+		known := false
+		for _, f := range ExportFormats() {
+			known = known || f == format
+		}
+		if !known {
+			return fmt.Errorf("unknown export format: format=%q", format)
+		}
+	}
+	if o.SparePercent < 0 {
+		return fmt.Errorf("invalid spare parts percentage: spares=%v", o.SparePercent)
+	}
+	return nil
+}
+
+// withSpares returns the number of pieces to buy for count pieces adding the spares, rounded up.
+func withSpares(count int, sparePercent float64) int {
+	// the tolerance keeps exact results, such as 5% of 100, from being rounded up
+	return count + int(math.Ceil(float64(count)*sparePercent/100-1e-9))
+}
+
+// export writes the parts list of the conversion in the given format, returning the name of the file.
+func (c *Conversion) export(prefix, format string, o ExportOptions) (string, error) {
+	var fileName string
+	var write func(w io.Writer) error
+	switch format {
+	case exportBrickLink:
+		fileName = prefix + "_bricklink.xml"
+		write = func(w io.Writer) error {
+			missing, err := writeBrickLinkXML(w, c.BillOfMaterials, o.SparePercent, o.ColorIDs)
+			for _, e := range missing {
+				log.Printf("WARNING: %d pieces of %s (%d) have been left out of the BrickLink wanted list, its BrickLink ID is unknown", e.Count, e.Name, e.LegoID)
+			}
+			return err
+		}
+	case exportRebrickable:
+		fileName = prefix + "_rebrickable.csv"
+		write = func(w io.Writer) error {
+			return writeRebrickableCSV(w, c.BillOfMaterials, o.SparePercent)
+		}
+	default:
+		return "", fmt.Errorf("unknown export format: format=%q", format)
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return "", fmt.Errorf("creating parts list file: err=%v", err)
+	}
+	defer f.Close()
+
+	return fileName, write(f)
+}
+
+// writeRebrickableCSV writes the bill of materials as a Rebrickable part list, with the header
+// Part,Color,Quantity, adding the spare pieces.
+func writeRebrickableCSV(w io.Writer, bom []BOMEntry, sparePercent float64) error {
+	csvWriter := csv.NewWriter(w)
+	_ = csvWriter.Write([]string{"Part", "Color", "Quantity"})
+	for _, e := range bom {
+		_ = csvWriter.Write([]string{e.PartID, strconv.Itoa(e.LegoID), strconv.Itoa(withSpares(e.Count, sparePercent))})
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return fmt.Errorf("writing Rebrickable part list: err=%v", err)
+	}
+	return nil
+}
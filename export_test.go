@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_withSpares(t *testing.T) {
+	tests := []struct {
+		count   int
+		percent float64
+		want    int
+	}{
+		{count: 100, percent: 0, want: 100},
+		{count: 100, percent: 5, want: 105},
+		{count: 3, percent: 10, want: 4},
+		{count: 0, percent: 10, want: 0},
+	}
+	for _, tt := range tests {
+		if got := withSpares(tt.count, tt.percent); got != tt.want {
+			t.Errorf("wrong pieces for count=%d, percent=%v: got=%d, expected=%d", tt.count, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestExportOptionsValidate(t *testing.T) {
+	if err := (ExportOptions{Formats: []string{exportBrickLink, exportRebrickable}, SparePercent: 5}).validate(); err != nil {
+		t.Errorf("unexpected error: err=%v", err)
+	}
+	if err := (ExportOptions{Formats: []string{"ldraw"}}).validate(); err == nil {
+		t.Errorf("an unknown format should return an error")
+	}
+	if err := (ExportOptions{SparePercent: -1}).validate(); err == nil {
+		t.Errorf("negative spares should return an error")
+	}
+}
+
+func Test_writeRebrickableCSV(t *testing.T) {
+	bom := []BOMEntry{
+		{PartID: "3024", LegoID: 0, Name: "Black", Count: 100},
+		{PartID: "3024", LegoID: 71, Name: "Light Bluish Gray", Count: 7},
+	}
+	var buf strings.Builder
+	if err := writeRebrickableCSV(&buf, bom, 10); err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	want := "Part,Color,Quantity\n3024,0,110\n3024,71,8\n"
+	if buf.String() != want {
+		t.Errorf("wrong part list: got=%q, expected=%q", buf.String(), want)
+	}
+
+	// the part list can be read back as a stock
+	stock, err := stockFromCSV(strings.NewReader(buf.String()), []string{"3024"})
+	if err != nil || !reflect.DeepEqual(stock, Stock{0: 110, 71: 8}) {
+		t.Errorf("wrong stock read back: got=%v, err=%v", stock, err)
+	}
+}
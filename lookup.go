@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// EuclideanMetric can be implemented by metrics whose Distance is the euclidean distance between
+// the projected colors, which allows searching the palette with a k-d tree.
+type EuclideanMetric interface {
+	IsEuclidean() bool
+}
+
+// colorMatcher finds the palette color closest to a pixel. It is built once per palette and
+// metric, and it is safe for concurrent use.
+//
+// Pixels are looked up in a cache indexed by their RGB value, so every distinct color in the image
+// is only searched once. On a miss, or for colors that don't come straight from the image such as
+// the ones being dithered, palettes compared with an euclidean metric are searched with a k-d tree
+// in O(log n), the rest are compared against every palette color.
+type colorMatcher struct {
+	metric  ColorMetric
+	palette []ColorVec
+	tree    *kdNode
+	cache   *rgbCache
+}
+
+func newColorMatcher(metric ColorMetric, palette []LegoColor) *colorMatcher {
+	m := &colorMatcher{
+		metric:  metric,
+		palette: projectPalette(metric, palette),
+		cache:   &rgbCache{},
+	}
+	if e, ok := metric.(EuclideanMetric); ok && e.IsEuclidean() {
+		indexes := make([]int, len(m.palette))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		m.tree = buildKDTree(m.palette, indexes, 0)
+	}
+	return m
+}
+
+// nearestPixel returns the index of the palette color closest to p.
+func (m *colorMatcher) nearestPixel(p Pixel) int {
+	if m.cache != nil {
+		if i, ok := m.cache.get(p); ok {
+			return i
+		}
+	}
+	i := m.nearest(m.metric.Project(p))
+	if m.cache != nil {
+		m.cache.set(p, i)
+	}
+	return i
+}
+
+// nearest returns the index of the palette color closest to the projected color v.
+func (m *colorMatcher) nearest(v ColorVec) int {
+	if m.tree != nil {
+		best, bestDistance := -1, math.MaxFloat64
+		m.tree.nearest(m.palette, v, &best, &bestDistance)
+		return best
+	}
+	return nearestColor(m.metric, m.palette, v)
+}
+
+// rgbCache memoizes the closest palette index of every 24 bit color. The cube is split in
+// 4096 blocks of 16x16x16 colors, allocated the first time one of their colors is used,
+// so the cache only takes memory for the regions of the cube present in the image.
+type rgbCache struct {
+	blocks [1 << 12]atomic.Pointer[rgbBlock]
+}
+
+// rgbBlock stores the palette index plus one, so zero means not computed yet.
+type rgbBlock [1 << 12]int32
+
+func rgbCacheKey(p Pixel) (block, offset int) {
+	r, g, b := clamp8(p.R), clamp8(p.G), clamp8(p.B)
+	return (r>>4)<<8 | (g>>4)<<4 | b>>4, (r&15)<<8 | (g&15)<<4 | b&15
+}
+
+func (c *rgbCache) get(p Pixel) (int, bool) {
+	block, offset := rgbCacheKey(p)
+	b := c.blocks[block].Load()
+	if b == nil {
+		return 0, false
+	}
+	v := atomic.LoadInt32(&b[offset])
+	return int(v) - 1, v != 0
+}
+
+func (c *rgbCache) set(p Pixel, i int) {
+	block, offset := rgbCacheKey(p)
+	b := c.blocks[block].Load()
+	if b == nil {
+		// if two goroutines race to allocate a block, the first one wins and the other value is lost
+		c.blocks[block].CompareAndSwap(nil, &rgbBlock{})
+		b = c.blocks[block].Load()
+	}
+	atomic.StoreInt32(&b[offset], int32(i+1))
+}
+
+// kdNode is a node of a 3 dimensional k-d tree holding palette indexes.
+type kdNode struct {
+	index       int
+	axis        int
+	left, right *kdNode
+}
+
+func buildKDTree(palette []ColorVec, indexes []int, depth int) *kdNode {
+	if len(indexes) == 0 {
+		return nil
+	}
+	axis := depth % 3
+	sort.Slice(indexes, func(i, j int) bool {
+		vi, vj := palette[indexes[i]][axis], palette[indexes[j]][axis]
+		if vi != vj {
+			return vi < vj
+		}
+		return indexes[i] < indexes[j]
+	})
+	median := len(indexes) / 2
+
+	return &kdNode{
+		index: indexes[median],
+		axis:  axis,
+		left:  buildKDTree(palette, indexes[:median], depth+1),
+		right: buildKDTree(palette, indexes[median+1:], depth+1),
+	}
+}
+
+// nearest walks the tree updating best with the closest palette index found so far, comparing
+// squared distances. Ties are resolved in favour of the lowest index, as a linear search would.
+func (n *kdNode) nearest(palette []ColorVec, v ColorVec, best *int, bestDistance *float64) {
+	if n == nil {
+		return
+	}
+
+	c := palette[n.index]
+	d0, d1, d2 := v[0]-c[0], v[1]-c[1], v[2]-c[2]
+	distance := d0*d0 + d1*d1 + d2*d2
+	if distance < *bestDistance || (distance == *bestDistance && n.index < *best) {
+		*best, *bestDistance = n.index, distance
+	}
+
+	diff := v[n.axis] - c[n.axis]
+	near, far := n.left, n.right
+	if diff >= 0 {
+		near, far = n.right, n.left
+	}
+	near.nearest(palette, v, best, bestDistance)
+	if diff*diff <= *bestDistance {
+		far.nearest(palette, v, best, bestDistance)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestColorMatcher(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, name := range []string{metricRGB, metricOklab, metricCIE76, metricCIEDE2000} {
+		t.Run(name, func(t *testing.T) {
+			metric, _ := MetricByName(name)
+			m := newColorMatcher(metric, defaultColors)
+			if e, ok := metric.(EuclideanMetric); ok && e.IsEuclidean() && m.tree == nil {
+				t.Fatalf("euclidean metrics should be searched with a k-d tree")
+			}
+
+			for i := 0; i < 2000; i++ {
+				p := Pixel{R: rnd.Intn(256), G: rnd.Intn(256), B: rnd.Intn(256)}
+				want := nearestColor(metric, m.palette, metric.Project(p))
+
+				// the second lookup is served from the cache
+				for j := 0; j < 2; j++ {
+					if got := m.nearestPixel(p); got != want {
+						t.Fatalf("matcher should agree with the linear search: pixel=%v, got=%d, expected=%d", p, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// benchmarkImage returns a smooth image with some noise, closer to a photo than random pixels.
+func benchmarkImage(width, height int) *image.RGBA {
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			noise := rnd.Intn(16)
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8(x * 255 / width),
+				G: uint8(y * 255 / height),
+				B: uint8((x + y + noise) * 255 / (width + height + 16)),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// BenchmarkMapFromImage compares the color matcher lookup structures against a brute force
+// search on a 1000x1000 conversion.
+func BenchmarkMapFromImage(b *testing.B) {
+	img := benchmarkImage(1000, 1000)
+
+	for _, name := range []string{metricRGB, metricCIEDE2000} {
+		for _, bruteForce := range []bool{true, false} {
+			b.Run(fmt.Sprintf("%s/bruteforce=%t", name, bruteForce), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					metric, _ := MetricByName(name)
+					lego := Lego{colors: defaultColors, metric: metric, bruteForce: bruteForce}
+					if _, err := lego.mapFromImage(context.Background(), img); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkColorMatcherNearest(b *testing.B) {
+	metric, _ := MetricByName(metricOklab)
+	m := newColorMatcher(metric, defaultColors)
+	v := metric.Project(Pixel{R: 90, G: 140, B: 200})
+
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			nearestColor(metric, m.palette, v)
+		}
+	})
+	b.Run("kdtree", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.nearest(v)
+		}
+	})
+}
@@ -13,7 +13,10 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/image/draw"
@@ -21,6 +24,10 @@ import (
 
 func init() {
 	rand.Seed(time.Now().UnixNano())
+
+	for i := range defaultColors {
+		defaultColors[i].IsTrans = isTransName(defaultColors[i].Name)
+	}
 }
 
 var (
@@ -218,42 +225,140 @@ var (
 )
 
 type LegoColor struct {
-	Hex    string
-	LegoID int
-	Name   string
-	R      int
-	G      int
-	B      int
+	Hex     string
+	LegoID  int
+	Name    string
+	R       int
+	G       int
+	B       int
+	IsTrans bool
+}
+
+// parseHexColor parses a color in the "RRGGBB" format, with or without a leading "#".
+// Shorter values are padded with leading zeros, so "6400" is "006400".
+func parseHexColor(hex string) (r, g, b int, err error) {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) > 6 || len(hex) == 0 {
+		return 0, 0, 0, fmt.Errorf("invalid hex color: hex=%q", hex)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid hex color: hex=%q", hex)
+	}
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff), nil
 }
 
-// https://rebrickable.com/downloads/
+// colorsFromCSV reads a palette from a CSV file, in either of these formats, told apart by their header:
+//   - legoid,name,hex,r,g,b: the format of the CSV files in this repository
+//   - id,name,rgb,is_trans: the colors.csv file from https://rebrickable.com/downloads/, any extra column is ignored
+//
+// The legacy format has no transparency column, so transparent colors are told by their name.
 func colorsFromCSV(f io.Reader) ([]LegoColor, error) {
 	csvReader := csv.NewReader(f)
-	records, err := csvReader.ReadAll()
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the CSV file is empty")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse file as CSV: err=%v", err)
 	}
 
-	// the funny -1, 1: is to take care of the header
-	var colors = make([]LegoColor, len(records)-1, len(records)-1)
-	for i, record := range records[1:] {
-		legoid, _ := strconv.Atoi(record[0])
-		r, _ := strconv.Atoi(record[3])
-		g, _ := strconv.Atoi(record[4])
-		b, _ := strconv.Atoi(record[5])
-		colors[i] = LegoColor{
-			LegoID: legoid,
-			Name:   record[1],
-			Hex:    record[2],
-			R:      r,
-			G:      g,
-			B:      b,
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	hasColumns := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := columns[name]; !ok {
+				return false
+			}
 		}
+		return true
+	}
+	rebrickable := hasColumns("id", "name", "rgb", "is_trans")
+	if !rebrickable && !hasColumns("legoid", "name", "hex", "r", "g", "b") {
+		return nil, fmt.Errorf("unknown CSV header, expected legoid,name,hex,r,g,b or id,name,rgb,is_trans: header=%q", strings.Join(header, ","))
+	}
+
+	var colors []LegoColor
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse file as CSV: err=%v", err)
+		}
+		line, _ := csvReader.FieldPos(0)
+
+		var c LegoColor
+		if rebrickable {
+			c, err = colorFromRebrickableRecord(record, columns)
+		} else {
+			c, err = colorFromLegacyRecord(record, columns)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		colors = append(colors, c)
 	}
 
 	return colors, nil
 }
 
+func colorFromRebrickableRecord(record []string, columns map[string]int) (LegoColor, error) {
+	id, err := strconv.Atoi(record[columns["id"]])
+	if err != nil {
+		return LegoColor{}, fmt.Errorf("invalid id: id=%q", record[columns["id"]])
+	}
+	hex := record[columns["rgb"]]
+	r, g, b, err := parseHexColor(hex)
+	if err != nil {
+		return LegoColor{}, err
+	}
+	isTrans, err := strconv.ParseBool(record[columns["is_trans"]])
+	if err != nil {
+		return LegoColor{}, fmt.Errorf("invalid is_trans: is_trans=%q", record[columns["is_trans"]])
+	}
+
+	return LegoColor{
+		LegoID:  id,
+		Name:    record[columns["name"]],
+		Hex:     hex,
+		R:       r,
+		G:       g,
+		B:       b,
+		IsTrans: isTrans,
+	}, nil
+}
+
+func colorFromLegacyRecord(record []string, columns map[string]int) (LegoColor, error) {
+	var values [4]int
+	for i, column := range []string{"legoid", "r", "g", "b"} {
+		v, err := strconv.Atoi(record[columns[column]])
+		if err != nil || (column != "legoid" && (v < 0 || v > 255)) {
+			return LegoColor{}, fmt.Errorf("invalid %s: %s=%q", column, column, record[columns[column]])
+		}
+		values[i] = v
+	}
+	name := record[columns["name"]]
+
+	return LegoColor{
+		LegoID:  values[0],
+		Name:    name,
+		Hex:     record[columns["hex"]],
+		R:       values[1],
+		G:       values[2],
+		B:       values[3],
+		IsTrans: isTransName(name),
+	}, nil
+}
+
+// isTransName tells if a color is transparent by its name, for palettes without a transparency flag.
+func isTransName(name string) bool {
+	return strings.Contains(name, "Trans")
+}
+
 // Pixel struct example
 type Pixel struct {
 	R int
@@ -279,29 +384,55 @@ func randStringRunes(n int) string {
 	return string(b)
 }
 
-func resizePNGImage(inimage io.Reader, x, y int) (*image.RGBA, error) {
-	// Decode the image (from PNG to image.Image):
-	source, err := png.Decode(inimage)
+// resizePNGImage scales the image to the size of the mosaic, cropping or padding it as the resize mode says.
+func resizePNGImage(source image.Image, opts ResizeOptions) (*image.RGBA, error) {
+	size, sr, dr, err := opts.layout(source)
 	if err != nil {
-		return nil, fmt.Errorf("decoding png: err=%v", err)
+		return nil, err
 	}
-	// Set the expected size that you want:
-	// dst := image.NewRGBA(image.Rect(x, y, src.Bounds().Max.X/2, src.Bounds().Max.Y/2))
+
 	m := image.NewRGBA(
 		image.Rectangle{
 			Min: image.Point{X: 0, Y: 0},
-			Max: image.Point{X: x, Y: y},
+			Max: size,
 		},
 	)
+	if opts.Mode == resizePad {
+		draw.Draw(m, m.Rect, image.NewUniform(opts.Background), image.Point{}, draw.Src)
+	}
+
+	scaler, err := resampler(opts.Resample, sr, dr)
+	if err != nil {
+		return nil, err
+	}
 
 	// Resize and encode:
-	draw.NearestNeighbor.Scale(m, m.Rect, source, source.Bounds(), draw.Over, nil)
+	scaler.Scale(m, dr, source, sr, draw.Over, nil)
 
 	return m, nil
 }
 
 type Lego struct {
 	colors []LegoColor
+	// metric used to find the closest color, the weighted RGB distance when nil
+	metric ColorMetric
+	dither Dithering
+	// workers converting the image in parallel, one per CPU when not set
+	workers int
+	// bruteForce disables the lookup structures of the color matcher, used to measure them
+	bruteForce bool
+	// stock limits the number of pieces of every color, unlimited when nil
+	stock Stock
+	// maxColors limits the number of colors used, keeping the ones that represent the image best
+	maxColors int
+	// prices restricts the colors picked by maxColors to the ones with a price, preferring the
+	// cheaper ones by priceWeight units of color distance per unit of currency
+	prices      PriceTable
+	priceWeight float64
+	// piece is the 1x1 piece the mosaic is built with, a plate when not set
+	piece Piece
+	// stacked tells if the pieces are stacked with their side facing the viewer, every row being a layer
+	stacked bool
 }
 
 // mapFromImage converts an image inside an io.Reader into its lego version, an image in the "png" format is expected
@@ -309,68 +440,199 @@ func (l *Lego) mapFromImage(ctx context.Context, imageData *image.RGBA) (*Conver
 	if len(l.colors) < 1 {
 		return nil, fmt.Errorf("there aren't disponible colors to work with")
 	}
+	if err := l.dither.validate(); err != nil {
+		return nil, err
+	}
 
-	x0len, y0len := imageData.Bounds().Min.X, imageData.Bounds().Min.Y
-	xlen, ylen := imageData.Bounds().Max.X, imageData.Bounds().Max.Y
-	legoimage := image.NewRGBA(
-		image.Rectangle{
-			Min: image.Point{X: x0len, Y: y0len},
-			Max: image.Point{X: xlen, Y: ylen},
-		},
-	)
+	metric := l.metric
+	if metric == nil {
+		metric = rgbMetric{}
+	}
+	if l.maxColors > 0 && l.maxColors < len(l.colors) {
+		// a color without stock or without price can't be one of the colors picked, and the colors
+		// picked need enough pieces in stock to cover the image
+		var candidates []LegoColor
+		var penalties []float64
+		var capacities []int
+		for _, c := range l.colors {
+			if l.stock != nil && l.stock[c.LegoID] == 0 {
+				continue
+			}
+			if l.prices != nil {
+				price, ok := l.prices.price(l.piece.orDefault().PartID, c.LegoID)
+				if !ok {
+					continue
+				}
+				penalties = append(penalties, price*l.priceWeight)
+			}
+			if l.stock != nil {
+				capacities = append(capacities, l.stock[c.LegoID])
+			}
+			candidates = append(candidates, c)
+		}
+		colors, err := reducePalette(ctx, imageData, metric, candidates, l.maxColors, penalties, capacities)
+		if err != nil && ctx.Err() != nil {
+			// interrupted before any row was converted
+			bounds := imageData.Bounds()
+			conversion := l.conversionFromGrid(bounds, newGrid(bounds.Dx(), bounds.Dy()))
+			return conversion, &PartialConversionError{RowsTotal: bounds.Dy(), Err: err}
+		}
+		if err != nil {
+			return nil, err
+		}
+		reduced := *l
+		reduced.colors, reduced.maxColors = colors, 0
+		return reduced.mapFromImage(ctx, imageData)
+	}
 
-	buildingMap := make([][]string, xlen)
-	uniqueColors := make(map[string]struct{}, xlen*ylen)
+	matcher := newColorMatcher(metric, l.colors)
+	if l.bruteForce {
+		matcher.tree, matcher.cache = nil, nil
+	}
+
+	bounds := imageData.Bounds()
+	grid := newGrid(bounds.Dx(), bounds.Dy())
+
+	var rowsDone int
+	var stockCost *StockCost
+	var err error
+	if l.stock != nil {
+		if _, ok := diffusionKernels[l.dither.Mode]; ok {
+			return nil, fmt.Errorf("error diffusion dithering can't be limited by a stock: dither=%q", l.dither.Mode)
+		}
+		pixelAt := func(x, y int) Pixel {
+			p := imageData.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			return Pixel{R: int(p.R), G: int(p.G), B: int(p.B)}
+		}
+		if _, ok := thresholdMap(l.dither.Mode); ok {
+			pixelAt = orderedPixelAt(imageData, len(l.colors), l.dither)
+		}
+		stockCost, err = assignWithStock(ctx, bounds, pixelAt, matcher, l.colors, l.stock, grid)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		rowsDone = completeRows(grid)
+	} else if _, ok := diffusionKernels[l.dither.Mode]; ok {
+		rowsDone, err = diffuse(ctx, imageData, matcher, l.dither, grid, l.workers)
+	} else if _, ok := thresholdMap(l.dither.Mode); ok {
+		// ordered dithering is applied to the pixels right before looking for their closest color
+		rowsDone, err = mapBands(ctx, l.workers, grid, bounds.Dy(), orderedPixel(imageData, matcher, l.dither))
+	} else {
+		rowsDone, err = mapBands(ctx, l.workers, grid, bounds.Dy(), quantizePixel(imageData, matcher))
+	}
 
-	var mindistance float64
+	conversion := l.conversionFromGrid(bounds, grid)
+	conversion.StockCost = stockCost
+	if err != nil {
+		return conversion, &PartialConversionError{RowsDone: rowsDone, RowsTotal: bounds.Dy(), Err: err}
+	}
+	return conversion, nil
+}
+
+// quantizePixel returns the index of the palette color closest to the pixel at (x, y).
+func quantizePixel(img *image.RGBA, m *colorMatcher) func(x, y int) int {
+	bounds := img.Bounds()
+	return func(x, y int) int {
+		p := img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+		return m.nearestPixel(Pixel{R: int(p.R), G: int(p.G), B: int(p.B)})
+	}
+}
+
+// nearestColor returns the index of the palette color closest to the projected pixel p.
+func nearestColor(metric ColorMetric, palette []ColorVec, p ColorVec) int {
 	var bestmatch int
-	var r, g, b uint8
-	for x := 0; x < xlen; x++ {
-		// mindistance = math.MaxFloat64 // Arbitrary high value to allow finding a lower number
-		buildingMap[x] = make([]string, ylen)
-
-		for y := 0; y < ylen; y++ {
-			mindistance = math.MaxFloat64 // Arbitrary high value to allow finding a lower number
-			r32, g32, b32, _ := imageData.At(x, y).RGBA()
-			r, g, b = uint8(r32), uint8(g32), uint8(b32)
-
-			// for each pixel, loop over all the lego colors to find the closest color
-			for i := range l.colors {
-				distance := calculateDistance(
-					Pixel{R: l.colors[i].R, G: l.colors[i].G, B: l.colors[i].B},
-					Pixel{R: int(r), G: int(g), B: int(b)},
-				)
-
-				// building a new image by replacing the real color for the most-close-lego-color
-				// https://cs.opensource.google/go/go/+/refs/tags/go1.17.5:src/image/image.go;l=96
-				if distance < mindistance {
-					mindistance = distance
-					bestmatch = i
-					legoimage.SetRGBA(x, y, color.RGBA{
-						R: uint8(l.colors[i].R), G: uint8(l.colors[i].G), B: uint8(l.colors[i].B), A: 255,
-					})
-				}
+	mindistance := math.MaxFloat64 // Arbitrary high value to allow finding a lower number
+	for i := range palette {
+		if distance := metric.Distance(p, palette[i]); distance < mindistance {
+			mindistance = distance
+			bestmatch = i
+		}
+	}
+	return bestmatch
+}
+
+// newGrid allocates a grid of palette indexes, indexed as grid[x][y].
+// Every cell starts as -1, meaning that no color has been assigned to it yet.
+func newGrid(width, height int) [][]int {
+	grid := make([][]int, width)
+	for x := range grid {
+		grid[x] = make([]int, height)
+		for y := range grid[x] {
+			grid[x][y] = -1
+		}
+	}
+	return grid
+}
+
+// completeRows returns the number of rows of the grid with a color assigned to every pixel.
+func completeRows(grid [][]int) int {
+	if len(grid) == 0 {
+		return 0
+	}
+	var rows int
+	for y := range grid[0] {
+		complete := true
+		for x := range grid {
+			if grid[x][y] < 0 {
+				complete = false
+				break
 			}
+		}
+		if complete {
+			rows++
+		}
+	}
+	return rows
+}
+
+// conversionFromGrid builds the lego image and building map from the palette index chosen for every pixel.
+// Pixels without a color assigned, such as the ones of an interrupted conversion, are left empty.
+func (l *Lego) conversionFromGrid(bounds image.Rectangle, grid [][]int) *Conversion {
+	legoimage := image.NewRGBA(bounds)
+	buildingMap := make([][]string, len(grid))
+	uniqueColors := make(map[string]struct{}, len(l.colors))
+
+	for x := range grid {
+		buildingMap[x] = make([]string, len(grid[x]))
+
+		for y, i := range grid[x] {
+			if i < 0 {
+				continue
+			}
+			c := l.colors[i]
 
 			// Set RGB color on a specific pixel
-			legoimage.SetRGBA(x, y, color.RGBA{
-				R: uint8(l.colors[bestmatch].R), G: uint8(l.colors[bestmatch].G), B: uint8(l.colors[bestmatch].B), A: 255,
-			})
+			legoimage.SetRGBA(bounds.Min.X+x, bounds.Min.Y+y, color.RGBA{R: uint8(c.R), G: uint8(c.G), B: uint8(c.B), A: 255})
 
-			// Add lego color to the building map
-			buildingMap[x][y] = fmt.Sprintf("[%d][%d] = R:%d, G:%d, B:%d\t-%s\n",
-				x, y, l.colors[bestmatch].R, l.colors[bestmatch].G, l.colors[bestmatch].B, l.colors[bestmatch].Name)
+			// Add lego color to the building map, stacked mosaics are built by layers from the bottom
+			if l.stacked {
+				buildingMap[x][y] = fmt.Sprintf("[layer %d][%d] = R:%d, G:%d, B:%d\t-%s\n", len(grid[x])-y, x, c.R, c.G, c.B, c.Name)
+			} else {
+				buildingMap[x][y] = fmt.Sprintf("[%d][%d] = R:%d, G:%d, B:%d\t-%s\n", x, y, c.R, c.G, c.B, c.Name)
+			}
 
-			uniqueColors[l.colors[bestmatch].Name] = struct{}{}
+			uniqueColors[c.Name] = struct{}{}
+		}
+	}
 
-		} // end y loop
-	} // end x loop
+	piece := l.piece.orDefault()
+	bom := billOfMaterials(l.colors, grid, piece.PartID)
+	var piecesUsed int
+	for _, e := range bom {
+		piecesUsed += e.Count
+	}
 
 	return &Conversion{
-		Image:      legoimage,
-		ColorsUsed: len(uniqueColors),
-		BuildMap:   buildingMap,
-	}, nil
+		Image:           legoimage,
+		PiecesUsed:      piecesUsed,
+		ColorsUsed:      len(uniqueColors),
+		BuildMap:        buildingMap,
+		BillOfMaterials: bom,
+		Piece:           piece,
+		Stacked:         l.stacked,
+		grid:            grid,
+		colors:          l.colors,
+	}
 }
 
 type Flags struct {
@@ -379,14 +641,91 @@ type Flags struct {
 	OutPath       string
 	XLen          int
 	YLen          int
+	Plates        string
+	PlateSize     int
+	WidthCM       float64
+	HeightCM      float64
+	Resize        string
+	PadColor      string
+	Resample      string
+	Metric        string
+	Dither        string
+	DitherLevel   float64
+	Serpentine    bool
+	Workers       int
+	IgnoreEXIF    bool
+	Include       string
+	Exclude       string
+	IncludeNames  string
+	ExcludeNames  string
+	IncludeIDs    string
+	ExcludeIDs    string
+	SolidOnly     bool
+	Dedupe        bool
+	Prefer        string
+	StockPath     string
+	StockParts    string
+	BrickLinkIDs  string
+	MaxColors     int
+	Export        string
+	Spares        float64
+	Merge         bool
+	MergeParts    string
+	PricesPath    string
+	PriceWeight   float64
+	Piece         string
+	Orientation   string
+	PreviewScale  int
 }
 
 func parseFlags() *Flags {
-	colorsCSVPath := flag.String("colors", "", "(Required) CSV file that contains a list of colors, with the format: legoid,name,hex,r,g,b ")
+	colorsCSVPath := flag.String("colors", "", "CSV file that contains a list of colors, with the format legoid,name,hex,r,g,b or the Rebrickable colors.csv format id,name,rgb,is_trans")
 	imagePath := flag.String("image", "", "(Required) Target image path")
 	outPath := flag.String("out", "", "")
-	xlen := flag.Int("xlen", 100, "")
-	ylen := flag.Int("ylen", 100, "")
+	xlen := flag.Int("xlen", 0, "Width of the mosaic in studs, derived from the image aspect ratio when only -ylen is given (default 100)")
+	ylen := flag.Int("ylen", 0, "Height of the mosaic in studs, derived from the image aspect ratio when only -xlen is given (default 100)")
+	resize := flag.String("resize", resizeStretch, "How to handle a different aspect ratio, one of: "+strings.Join(ResizeModes(), ", "))
+	resample := flag.String("resample", resampleAuto, "Resampling kernel, one of: "+strings.Join(ResampleModes(), ", ")+" (auto averages the area of every stud when shrinking the image more than 4 times)")
+	plates := flag.String("plates", "", "Size of the mosaic in baseplates, with the format COLUMNSxROWS, e.g. 3x2")
+	plateSize := flag.Int("plate-size", defaultPlateSize, "Side of the baseplates in studs, e.g. 16, 32 or 48")
+	widthCM := flag.Float64("width-cm", 0, "Width of the mosaic in centimeters, derived from the image aspect ratio when only -height-cm is given")
+	heightCM := flag.Float64("height-cm", 0, "Height of the mosaic in centimeters, derived from the image aspect ratio when only -width-cm is given")
+	padColor := flag.String("pad-color", "FFFFFF", "Hex color of the borders added by the pad resize mode")
+	metric := flag.String("metric", metricRGB, "Color distance metric, one of: "+strings.Join(MetricNames(), ", "))
+	dither := flag.String("dither", ditherNone, "Dithering mode, one of: "+strings.Join(DitherModes(), ", "))
+	ditherLevel := flag.Float64("dither-strength", 1, "Share of the quantization error propagated when dithering, from 0 to 1 (amplitude of the threshold map for the ordered modes)")
+	serpentine := flag.Bool("serpentine", true, "Alternate the scanning direction on every row when dithering")
+	ignoreEXIF := flag.Bool("ignore-exif", false, "Don't rotate the image as its EXIF orientation says")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of workers converting the image in parallel")
+	include := flag.String("include", "", "Comma separated color categories to use, any of: "+strings.Join(ColorCategories(), ", "))
+	exclude := flag.String("exclude", "", "Comma separated color categories not to use, e.g. trans,chrome,modulex")
+	includeNames := flag.String("include-names", "", "Regular expression the names of the colors to use have to match")
+	excludeNames := flag.String("exclude-names", "", "Regular expression matching the names of the colors not to use")
+	includeIDs := flag.String("include-ids", "", "Comma separated IDs of the colors to use")
+	excludeIDs := flag.String("exclude-ids", "", "Comma separated IDs of the colors not to use")
+	solidOnly := flag.Bool("solid-only", false, "Use only solid colors, leaving out the transparent, chrome, glitter, speckle, pearl, metallic, modulex, glow and opal ones")
+
+	dedupe := flag.Bool("dedupe", false, "Keep a single color of every group of colors with the same RGB value, the most common one or the one given by -prefer")
+	prefer := flag.String("prefer", "", "Comma separated IDs of the colors kept first when several colors have the same RGB value")
+
+	stockPath := flag.String("stock", "", "File with the pieces owned of every color, only those pieces are used: a CSV file with the format color,quantity, a Rebrickable part list CSV or a BrickLink XML file")
+	stockParts := flag.String("stock-parts", "", "Comma separated IDs of the parts counted from Rebrickable and BrickLink part lists (default the IDs of the -piece)")
+	bricklinkIDs := flag.String("bricklink-colors", "", "CSV file with the format rebrickable,bricklink adding or replacing BrickLink color IDs")
+
+	maxColors := flag.Int("max-colors", 0, "Maximum number of colors of the mosaic, picking the ones that represent the image best (no limit when 0)")
+
+	export := flag.String("export", "", "Comma separated formats of the parts lists to write, any of: "+strings.Join(ExportFormats(), ", "))
+	spares := flag.Float64("spares", 0, "Spare pieces added to every color of the exported parts lists, in percent")
+
+	merge := flag.Bool("merge", false, "Merge the studs of the same color into larger parts to use fewer pieces")
+	mergeParts := flag.String("merge-parts", defaultMergeParts, "Comma separated sizes of the parts used to merge the studs, 1x1 included")
+
+	pricesPath := flag.String("prices", "", "CSV file with the unit price of the parts, with the format part,color,price (an empty color applies to every color)")
+	priceWeight := flag.Float64("price-weight", 100, "Units of color distance a unit of currency is worth when picking the colors with -max-colors")
+
+	piece := flag.String("piece", "", "1x1 piece the mosaic is built with, one of: "+strings.Join(PieceNames(), ", ")+" (default plate, or the piece of a stacked -orientation)")
+	orientation := flag.String("orientation", orientationStudsUp, "How the pieces face the viewer, one of: "+strings.Join(Orientations(), ", ")+" (stacked mosaics are built as a wall of bricks or plates, every row being a layer)")
+	previewScale := flag.Int("preview-scale", defaultPreviewScale, "Side of every stud of the preview in pixels, drawn in the style of the -piece when larger than 1 (1 draws a flat pixel per stud)")
 
 	flag.Parse()
 
@@ -396,6 +735,41 @@ func parseFlags() *Flags {
 		OutPath:       *outPath,
 		XLen:          *xlen,
 		YLen:          *ylen,
+		Plates:        *plates,
+		PlateSize:     *plateSize,
+		WidthCM:       *widthCM,
+		HeightCM:      *heightCM,
+		Resize:        *resize,
+		PadColor:      *padColor,
+		Resample:      *resample,
+		Metric:        *metric,
+		Dither:        *dither,
+		DitherLevel:   *ditherLevel,
+		Serpentine:    *serpentine,
+		Workers:       *workers,
+		IgnoreEXIF:    *ignoreEXIF,
+		Include:       *include,
+		Exclude:       *exclude,
+		IncludeNames:  *includeNames,
+		ExcludeNames:  *excludeNames,
+		IncludeIDs:    *includeIDs,
+		ExcludeIDs:    *excludeIDs,
+		SolidOnly:     *solidOnly,
+		Dedupe:        *dedupe,
+		Prefer:        *prefer,
+		StockPath:     *stockPath,
+		StockParts:    *stockParts,
+		BrickLinkIDs:  *bricklinkIDs,
+		MaxColors:     *maxColors,
+		Export:        *export,
+		Spares:        *spares,
+		Merge:         *merge,
+		MergeParts:    *mergeParts,
+		PricesPath:    *pricesPath,
+		PriceWeight:   *priceWeight,
+		Piece:         *piece,
+		Orientation:   *orientation,
+		PreviewScale:  *previewScale,
 	}
 }
 
@@ -404,22 +778,84 @@ type Conversion struct {
 	PiecesUsed int
 	ColorsUsed int
 	BuildMap   [][]string
+	// BillOfMaterials holds the pieces needed of every color, the most used color first
+	BillOfMaterials []BOMEntry
+	// PlateSize is the side, in studs, of the baseplates the mosaic is built on
+	PlateSize int
+	// Piece is the 1x1 piece the mosaic is built with
+	Piece Piece
+	// Stacked tells if the pieces are stacked with their side facing the viewer, every row being a layer
+	Stacked bool
+	// PreviewScale is the side, in pixels, of every stud of the preview
+	PreviewScale int
+	// StockCost is how much the stock limits worsened the conversion, nil when there are no limits
+	StockCost *StockCost
+	// Placements holds the parts covering the mosaic once its studs are merged into larger parts
+	Placements []Placement
+	// Bill is the estimated cost of the pieces, nil when there are no prices
+	Bill *Bill
+
+	// grid holds the index in colors of the color of every stud, indexed as grid[x][y]
+	grid   [][]int
+	colors []LegoColor
 }
 
-func (c *Conversion) result(outPath string) error {
+func (c *Conversion) result(outPath string, exports ExportOptions) error {
 	randResultName := randStringRunes(8)
 
-	var piecesUsed int
 	buildMapFileName := outPath + randResultName + "_build_map.txt"
 	f, _ := os.Create(buildMapFileName)
-	for i := 0; i < len(c.BuildMap); i++ {
-		for j := 0; j < len(c.BuildMap[i]); j++ {
-			_, _ = f.WriteString(c.BuildMap[i][j])
-			piecesUsed++
+	if c.Stacked && len(c.BuildMap) > 0 {
+		// layer by layer, from the bottom
+		for j := len(c.BuildMap[0]) - 1; j >= 0; j-- {
+			for i := 0; i < len(c.BuildMap); i++ {
+				_, _ = f.WriteString(c.BuildMap[i][j])
+			}
+		}
+	} else {
+		for i := 0; i < len(c.BuildMap); i++ {
+			for j := 0; j < len(c.BuildMap[i]); j++ {
+				_, _ = f.WriteString(c.BuildMap[i][j])
+			}
 		}
 	}
 	f.Close()
-	c.PiecesUsed = piecesUsed
+
+	bomFileName := outPath + randResultName + "_bom.csv"
+	bomFile, err := os.Create(bomFileName)
+	if err != nil {
+		return fmt.Errorf("creating bill of materials file: err=%v", err)
+	}
+	defer bomFile.Close()
+	if err := writeBOMCSV(bomFile, c.BillOfMaterials); err != nil {
+		return err
+	}
+
+	var placementsFileName string
+	if len(c.Placements) > 0 {
+		placementsFileName = outPath + randResultName + "_placements.csv"
+		placementsFile, err := os.Create(placementsFileName)
+		if err != nil {
+			return fmt.Errorf("creating placements file: err=%v", err)
+		}
+		defer placementsFile.Close()
+		var layers int
+		if c.Stacked {
+			layers = c.Image.Bounds().Dy()
+		}
+		if err := writePlacementsCSV(placementsFile, c.Placements, layers); err != nil {
+			return err
+		}
+	}
+
+	exportFileNames := make([]string, 0, len(exports.Formats))
+	for _, format := range exports.Formats {
+		fileName, err := c.export(outPath+randResultName, format, exports)
+		if err != nil {
+			return err
+		}
+		exportFileNames = append(exportFileNames, fileName)
+	}
 
 	// create and encode output image
 	ImageResultFileName := outPath + randResultName + "_out.png"
@@ -427,17 +863,43 @@ func (c *Conversion) result(outPath string) error {
 	if err != nil {
 		return fmt.Errorf("creating output file: err=%v", err)
 	}
-	png.Encode(outfile, c.Image)
+	if scale := c.previewScale(c.PreviewScale); scale < c.PreviewScale {
+		log.Printf("INFO: the preview is drawn with %d pixels per stud instead of %d, to keep it within %d pixels per side", scale, c.PreviewScale, maxPreviewSide)
+	}
+	png.Encode(outfile, c.preview(c.PreviewScale))
 
 	log.Printf("For this Lego conversion have been used %d pieces and %d colors\n", c.PiecesUsed, c.ColorsUsed)
+	widthMM, heightMM, depthMM := c.physicalSize()
+	if c.Stacked {
+		log.Printf("The mosaic measures %.1fx%.1f cm, %.1f mm thick, and has %d layers of %ss", widthMM/10, heightMM/10, depthMM, c.Image.Bounds().Dy(), c.Piece.Name)
+	} else {
+		plates, plateSize := c.baseplates()
+		log.Printf("The mosaic measures %.1fx%.1f cm, %.1f mm thick, and needs %d baseplates of %dx%d studs", widthMM/10, heightMM/10, depthMM, plates, plateSize, plateSize)
+	}
+	if c.StockCost != nil {
+		log.Printf("The stock limits gave another color to %d pixels, raising the mean color distance from %.2f to %.2f",
+			c.StockCost.Substituted, c.StockCost.MeanDistanceUnlimited, c.StockCost.MeanDistance)
+	}
+	if c.Bill != nil {
+		log.Printf("The pieces cost an estimated %.2f, %d pieces without a price left out", c.Bill.Total, c.Bill.Unpriced)
+	}
 	log.Printf("The image preview has been generated at %q ", ImageResultFileName)
 	log.Printf("The building map has been generated at %q", buildMapFileName)
+	log.Printf("The bill of materials has been generated at %q", bomFileName)
+	if placementsFileName != "" {
+		log.Printf("The placement of every part has been generated at %q", placementsFileName)
+	}
+	for _, fileName := range exportFileNames {
+		log.Printf("The parts list has been generated at %q", fileName)
+	}
 
 	return nil
 }
 
 func run() {
-	ctx := context.Background()
+	// interrupting the program cancels the conversion
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	flags := parseFlags()
 
 	if flags.ImagePath == "" {
@@ -468,29 +930,216 @@ func run() {
 		}
 	}
 
+	paletteFilter, err := paletteFilterFromFlags(flags)
+	if err != nil {
+		log.Printf("parsing palette filters: err=%v", err)
+		os.Exit(1)
+	}
+	csvColors, err = paletteFilter.Apply(csvColors)
+	if err != nil {
+		log.Printf("filtering palette: err=%v", err)
+		os.Exit(1)
+	}
+	if len(csvColors) == 0 {
+		log.Printf("filtering palette: no color passes the filters")
+		os.Exit(1)
+	}
+	colorIDs := defaultColorIDMap()
+	if flags.BrickLinkIDs != "" {
+		colorIDsFile, err := os.Open(flags.BrickLinkIDs)
+		if err != nil {
+			log.Printf("opening BrickLink colors CSV: file=%s, err=%v", flags.BrickLinkIDs, err)
+			os.Exit(1)
+		}
+		defer colorIDsFile.Close()
+
+		if err := colorIDs.addFromCSV(colorIDsFile); err != nil {
+			log.Printf("retrieving BrickLink colors: file=%s, err=%v", flags.BrickLinkIDs, err)
+			os.Exit(1)
+		}
+	}
+
+	exports := ExportOptions{
+		Formats:      splitList(flags.Export),
+		SparePercent: flags.Spares,
+		ColorIDs:     colorIDs,
+	}
+	if err := exports.validate(); err != nil {
+		log.Printf("parsing export options: err=%v", err)
+		os.Exit(1)
+	}
+
+	piece, err := pieceForOrientation(flags.Orientation, flags.Piece)
+	if err != nil {
+		log.Printf("selecting piece: err=%v", err)
+		os.Exit(1)
+	}
+	stacked := isStacked(flags.Orientation)
+	if flags.PreviewScale < 1 {
+		log.Printf("invalid preview scale: scale=%d", flags.PreviewScale)
+		os.Exit(1)
+	}
+
+	var mergeParts []Part
+	if flags.Merge {
+		if len(piece.Parts) == 0 {
+			log.Printf("parsing merge parts: the %s pieces can't be merged into larger parts", piece.Name)
+			os.Exit(1)
+		}
+		mergeParts, err = partsBySize(piece.Parts, splitList(flags.MergeParts))
+		if err != nil {
+			log.Printf("parsing merge parts: err=%v", err)
+			os.Exit(1)
+		}
+	}
+
+	var prices PriceTable
+	if flags.PricesPath != "" {
+		pricesFile, err := os.Open(flags.PricesPath)
+		if err != nil {
+			log.Printf("opening prices CSV: file=%s, err=%v", flags.PricesPath, err)
+			os.Exit(1)
+		}
+		defer pricesFile.Close()
+
+		prices, err = priceTableFromCSV(pricesFile)
+		if err != nil {
+			log.Printf("retrieving prices: file=%s, err=%v", flags.PricesPath, err)
+			os.Exit(1)
+		}
+	}
+
+	var stock Stock
+	if flags.StockPath != "" {
+		stockFile, err := os.Open(flags.StockPath)
+		if err != nil {
+			log.Printf("opening stock CSV: file=%s, err=%v", flags.StockPath, err)
+			os.Exit(1)
+		}
+		defer stockFile.Close()
+
+		var unknown []int
+		stockParts := piece.partIDs()
+		if flags.StockParts != "" {
+			stockParts = splitList(flags.StockParts)
+		}
+		stock, unknown, err = loadStock(stockFile, stockParts, colorIDs)
+		if err != nil {
+			log.Printf("retrieving stock: file=%s, err=%v", flags.StockPath, err)
+			os.Exit(1)
+		}
+		if len(unknown) > 0 {
+			log.Printf("WARNING: the pieces of the BrickLink colors %v have been left out of the stock, their Rebrickable IDs are unknown", unknown)
+		}
+	}
+
+	if flags.Dedupe {
+		prefer, err := parseIDList(flags.Prefer)
+		if err != nil {
+			log.Printf("parsing preferred colors: err=%v", err)
+			os.Exit(1)
+		}
+		// of the colors with the same RGB value, keep the ones in stock
+		prefer = append(prefer, stock.IDs()...)
+		var groups []ColorGroup
+		csvColors, groups = dedupePalette(csvColors, prefer)
+		for _, g := range groups {
+			log.Printf("INFO: colors with the same RGB value #%02X%02X%02X, using %s", g.Kept.R, g.Kept.G, g.Kept.B, g)
+		}
+	}
+
 	inputImage, err := os.Open(flags.ImagePath)
 	if err != nil {
-		log.Printf("opening PNG image: file=%s, err=%v", flags.ImagePath, err)
+		log.Printf("opening image: file=%s, err=%v", flags.ImagePath, err)
 		os.Exit(1)
 	}
 	defer inputImage.Close()
 
-	resizedimg, err := resizePNGImage(inputImage, flags.XLen, flags.YLen)
+	sourceimg, format, err := loadImage(inputImage, flags.IgnoreEXIF)
+	if err != nil {
+		log.Printf("loading image: file=%s, err=%v", flags.ImagePath, err)
+		os.Exit(1)
+	}
+
+	padR, padG, padB, err := parseHexColor(flags.PadColor)
+	if err != nil {
+		log.Printf("parsing pad color: err=%v", err)
+		os.Exit(1)
+	}
+
+	platesX, platesY, err := parsePlates(flags.Plates)
+	if err != nil {
+		log.Printf("parsing mosaic size: err=%v", err)
+		os.Exit(1)
+	}
+	cellAspect, rowHeightMM := 1.0, studPitchMM
+	if stacked {
+		cellAspect, rowHeightMM = piece.stackedAspect(), piece.Height
+	}
+	width, height, err := MosaicSize{
+		Width:       flags.XLen,
+		Height:      flags.YLen,
+		PlatesX:     platesX,
+		PlatesY:     platesY,
+		PlateSize:   flags.PlateSize,
+		WidthCM:     flags.WidthCM,
+		HeightCM:    flags.HeightCM,
+		RowHeightMM: rowHeightMM,
+	}.studs()
+	if err != nil {
+		log.Printf("parsing mosaic size: err=%v", err)
+		os.Exit(1)
+	}
+
+	resizedimg, err := resizePNGImage(sourceimg, ResizeOptions{
+		Width:      width,
+		Height:     height,
+		Mode:       flags.Resize,
+		Background: color.RGBA{R: uint8(padR), G: uint8(padG), B: uint8(padB), A: 255},
+		Resample:   flags.Resample,
+		CellAspect: cellAspect,
+	})
 	if err != nil {
 		log.Printf("resizing image: err=%v", err)
 		os.Exit(1)
 	}
 
+	metric, err := MetricByName(flags.Metric)
+	if err != nil {
+		log.Printf("selecting color metric: err=%v", err)
+		os.Exit(1)
+	}
+
 	// parse pixels, find closest color based on the available lego pieces
-	lego := Lego{colors: csvColors}
+	lego := Lego{
+		colors:      csvColors,
+		metric:      metric,
+		dither:      Dithering{Mode: flags.Dither, Strength: flags.DitherLevel, Serpentine: flags.Serpentine},
+		workers:     flags.Workers,
+		stock:       stock,
+		maxColors:   flags.MaxColors,
+		prices:      prices,
+		priceWeight: flags.PriceWeight,
+		piece:       piece,
+		stacked:     stacked,
+	}
 	conversion, err := lego.mapFromImage(ctx, resizedimg)
 	if err != nil {
 		log.Printf("mapping image to lego artboard: err=%v", err)
 		os.Exit(1)
 	}
 
-	log.Printf("INFO: input=%q, dimensions=%dx%d", flags.ImagePath, flags.XLen, flags.YLen)
-	err = conversion.result(flags.OutPath)
+	conversion.PlateSize = flags.PlateSize
+	conversion.PreviewScale = flags.PreviewScale
+	if flags.Merge {
+		conversion.mergeParts(mergeParts, prices)
+	}
+	if prices != nil {
+		conversion.Bill = prices.bill(conversion.BillOfMaterials)
+	}
+
+	log.Printf("INFO: input=%q, format=%s, dimensions=%dx%d", flags.ImagePath, format, resizedimg.Rect.Dx(), resizedimg.Rect.Dy())
+	err = conversion.result(flags.OutPath, exports)
 	if err != nil {
 		log.Printf("processing result: err=%v", err)
 		os.Exit(1)
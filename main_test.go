@@ -2,6 +2,8 @@ package main
 
 import (
 	"math"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -270,3 +272,82 @@ func Test_calculateDistance(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseHexColor(t *testing.T) {
+	tests := []struct {
+		hex     string
+		want    Pixel
+		wantErr bool
+	}{
+		{hex: "C91A09", want: Pixel{R: 201, G: 26, B: 9}},
+		{hex: "#0055bf", want: Pixel{R: 0, G: 85, B: 191}},
+		{hex: "6400", want: Pixel{R: 0, G: 100, B: 0}},
+		{hex: "", wantErr: true},
+		{hex: "GGGGGG", wantErr: true},
+		{hex: "1234567", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, g, b, err := parseHexColor(tt.hex)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("unexpected error: hex=%q, err=%v", tt.hex, err)
+			continue
+		}
+		if got := (Pixel{R: r, G: g, B: b}); !tt.wantErr && got != tt.want {
+			t.Errorf("wrong color parsed: hex=%q, got=%v, expected=%v", tt.hex, got, tt.want)
+		}
+	}
+}
+
+func Test_colorsFromCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []LegoColor
+		wantErr string
+	}{
+		{
+			name: "legacy format",
+			csv:  "legoid,name,hex,r,g,b\n4,Red,C91A09,201,26,9\n47,Trans-Clear,FCFCFC,252,252,252\n",
+			want: []LegoColor{
+				{LegoID: 4, Name: "Red", Hex: "C91A09", R: 201, G: 26, B: 9},
+				{LegoID: 47, Name: "Trans-Clear", Hex: "FCFCFC", R: 252, G: 252, B: 252, IsTrans: true},
+			},
+		},
+		{
+			name: "rebrickable format",
+			csv:  "id,name,rgb,is_trans\n4,Red,C91A09,f\n36,Trans-Red,C91A09,t\n",
+			want: []LegoColor{
+				{LegoID: 4, Name: "Red", Hex: "C91A09", R: 201, G: 26, B: 9},
+				{LegoID: 36, Name: "Trans-Red", Hex: "C91A09", R: 201, G: 26, B: 9, IsTrans: true},
+			},
+		},
+		{
+			name: "rebrickable format with extra columns",
+			csv:  "id,name,rgb,is_trans,num_parts,num_sets,y1,y2\n0,Black,05131D,False,1000,100,1957,2024\n",
+			want: []LegoColor{{LegoID: 0, Name: "Black", Hex: "05131D", R: 5, G: 19, B: 29}},
+		},
+		{name: "unknown header", csv: "a,b,c\n1,2,3\n", wantErr: "unknown CSV header"},
+		{name: "invalid id", csv: "id,name,rgb,is_trans\n4,Red,C91A09,f\nX,Blue,0055BF,f\n", wantErr: "line 3: invalid id"},
+		{name: "invalid hex", csv: "id,name,rgb,is_trans\n4,Red,ZZZZZZ,f\n", wantErr: "line 2: invalid hex color"},
+		{name: "invalid is_trans", csv: "id,name,rgb,is_trans\n4,Red,C91A09,maybe\n", wantErr: "line 2: invalid is_trans"},
+		{name: "invalid channel", csv: "legoid,name,hex,r,g,b\n4,Red,C91A09,201,260,9\n", wantErr: "line 2: invalid g"},
+		{name: "missing fields", csv: "legoid,name,hex,r,g,b\n4,Red,C91A09,201\n", wantErr: "line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := colorsFromCSV(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("wrong error: err=%v, expected=%q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrong colors: got=%+v, expected=%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Part is a rectangular part that covers Width by Length studs of the mosaic, Width being the shortest side.
+type Part struct {
+	ID     string
+	Width  int
+	Length int
+}
+
+// String returns the size of the part, such as "2x4".
+func (p Part) String() string {
+	return fmt.Sprintf("%dx%d", p.Width, p.Length)
+}
+
+// Area returns the number of studs the part covers.
+func (p Part) Area() int {
+	return p.Width * p.Length
+}
+
+// plateParts are the plates a mosaic can be built with.
+var plateParts = []Part{
+	{ID: "3024", Width: 1, Length: 1},
+	{ID: "3023", Width: 1, Length: 2},
+	{ID: "3623", Width: 1, Length: 3},
+	{ID: "3710", Width: 1, Length: 4},
+	{ID: "3666", Width: 1, Length: 6},
+	{ID: "3460", Width: 1, Length: 8},
+	{ID: "4477", Width: 1, Length: 10},
+	{ID: "60479", Width: 1, Length: 12},
+	{ID: "3022", Width: 2, Length: 2},
+	{ID: "3021", Width: 2, Length: 3},
+	{ID: "3020", Width: 2, Length: 4},
+	{ID: "3795", Width: 2, Length: 6},
+	{ID: "3034", Width: 2, Length: 8},
+	{ID: "3832", Width: 2, Length: 10},
+	{ID: "2445", Width: 2, Length: 12},
+	{ID: "3031", Width: 4, Length: 4},
+	{ID: "3032", Width: 4, Length: 6},
+	{ID: "3035", Width: 4, Length: 8},
+}
+
+// defaultMergeParts are the sizes of the parts used to merge studs when none are given.
+const defaultMergeParts = "1x1,1x2,1x3,1x4,1x6,1x8,2x2,2x3,2x4"
+
+// partsBySize returns the parts with the given sizes, such as "1x2" or "4x2", out of the available ones.
+// The 1x1 part is required, as it's the only one that can cover any stud.
+func partsBySize(available []Part, sizes []string) ([]Part, error) {
+	var parts []Part
+	var hasUnit bool
+	for _, size := range sizes {
+		var w, l int
+		if n, err := fmt.Sscanf(strings.ToLower(size), "%dx%d", &w, &l); err != nil || n != 2 || fmt.Sprintf("%dx%d", w, l) != strings.ToLower(size) {
+			return nil, fmt.Errorf("invalid part size, expected WIDTHxLENGTH: size=%q", size)
+		}
+		if w > l {
+			w, l = l, w
+		}
+
+		found := false
+		for _, p := range available {
+			if p.Width == w && p.Length == l {
+				parts = append(parts, p)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown part size: size=%q", size)
+		}
+		hasUnit = hasUnit || (w == 1 && l == 1)
+	}
+	if !hasUnit {
+		return nil, fmt.Errorf("the 1x1 part is required to merge the studs: parts=%q", strings.Join(sizes, ","))
+	}
+	return parts, nil
+}
+
+// Placement is a part placed on the mosaic. X and Y are the stud of its top left corner; with a
+// Rotation of 0 its length goes along the X axis, and with a Rotation of 90 along the Y axis.
+type Placement struct {
+	Part     Part
+	Color    LegoColor
+	X        int
+	Y        int
+	Rotation int
+}
+
+// size returns the number of studs the placement covers along the X and Y axis.
+func (p Placement) size() (int, int) {
+	if p.Rotation == 90 {
+		return p.Part.Width, p.Part.Length
+	}
+	return p.Part.Length, p.Part.Width
+}
+
+// mergeStuds covers the grid of palette indexes with the given parts, every part covering studs of
+// a single color. Studs are visited row by row, and every stud not covered yet is covered with the
+// largest part that fits from it, which keeps the number of parts low. Studs without a color are
+// left uncovered.
+//
+// With a price table the cheapest part per stud that fits is used instead, which keeps the cost
+// low. Parts without a price in a color aren't used for it, but for the 1x1 part, always used last.
+//
+// Parts are turned 90 degrees to fit only when rotate is set, otherwise their length always goes
+// along the X axis.
+func mergeStuds(colors []LegoColor, grid [][]int, parts []Part, prices PriceTable, rotate bool) []Placement {
+	// the parts to try for every color, in order
+	order := make([][]Part, len(colors))
+	partsFor := func(i int) []Part {
+		if order[i] == nil {
+			order[i] = partsByCost(parts, colors[i], prices)
+		}
+		return order[i]
+	}
+
+	width := len(grid)
+	if width == 0 {
+		return nil
+	}
+	height := len(grid[0])
+	covered := make([][]bool, width)
+	for x := range covered {
+		covered[x] = make([]bool, height)
+	}
+
+	// fits tells if the w by h rectangle from (x, y) has only uncovered studs of the color i
+	fits := func(x, y, w, h, i int) bool {
+		if x+w > width || y+h > height {
+			return false
+		}
+		for dx := 0; dx < w; dx++ {
+			for dy := 0; dy < h; dy++ {
+				if covered[x+dx][y+dy] || grid[x+dx][y+dy] != i {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	var placements []Placement
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := grid[x][y]
+			if i < 0 || covered[x][y] {
+				continue
+			}
+			for _, part := range partsFor(i) {
+				placement := Placement{Part: part, Color: colors[i], X: x, Y: y}
+				w, h := placement.size()
+				if !fits(x, y, w, h, i) {
+					if part.Width == part.Length || !rotate {
+						continue
+					}
+					placement.Rotation = 90
+					if w, h = placement.size(); !fits(x, y, w, h, i) {
+						continue
+					}
+				}
+				for dx := 0; dx < w; dx++ {
+					for dy := 0; dy < h; dy++ {
+						covered[x+dx][y+dy] = true
+					}
+				}
+				placements = append(placements, placement)
+				break
+			}
+		}
+	}
+	return placements
+}
+
+// partsByCost sorts the parts from the cheapest per stud in the given color to the most expensive,
+// or from the largest to the smallest without prices, leaving out the parts without a price.
+func partsByCost(parts []Part, c LegoColor, prices PriceTable) []Part {
+	type pricedPart struct {
+		part    Part
+		perStud float64
+	}
+	var priced []pricedPart
+	for _, p := range parts {
+		pp := pricedPart{part: p}
+		if prices != nil {
+			price, ok := prices.price(p.ID, c.LegoID)
+			if !ok && p.Area() > 1 {
+				continue
+			}
+			if !ok {
+				// the 1x1 part covers any stud, so it's kept as the last option
+				price = math.Inf(1)
+			}
+			pp.perStud = price / float64(p.Area())
+		}
+		priced = append(priced, pp)
+	}
+
+	sort.SliceStable(priced, func(i, j int) bool {
+		a, b := priced[i], priced[j]
+		switch {
+		case a.perStud != b.perStud:
+			return a.perStud < b.perStud
+		case a.part.Area() != b.part.Area():
+			return a.part.Area() > b.part.Area()
+		}
+		return a.part.Length > b.part.Length
+	})
+	sorted := make([]Part, len(priced))
+	for i, pp := range priced {
+		sorted[i] = pp.part
+	}
+	return sorted
+}
+
+// bomFromPlacements counts the pieces of every part and color, sorted as sortBOM does.
+func bomFromPlacements(placements []Placement) []BOMEntry {
+	type key struct {
+		partID string
+		legoID int
+	}
+	index := make(map[key]int)
+	var bom []BOMEntry
+	for _, p := range placements {
+		k := key{p.Part.ID, p.Color.LegoID}
+		i, ok := index[k]
+		if !ok {
+			i = len(bom)
+			index[k] = i
+			bom = append(bom, BOMEntry{
+				PartID: p.Part.ID,
+				LegoID: p.Color.LegoID,
+				Name:   p.Color.Name,
+				Hex:    fmt.Sprintf("%02X%02X%02X", p.Color.R, p.Color.G, p.Color.B),
+			})
+		}
+		bom[i].Count++
+	}
+	for i := range bom {
+		bom[i].Percentage = float64(bom[i].Count) / float64(len(placements)) * 100
+	}
+	sortBOM(bom)
+	return bom
+}
+
+// mergeParts covers the mosaic with the given parts instead of a piece per stud, replacing the
+// bill of materials and the pieces used with the ones of the parts placed. The cost of the parts
+// is kept low when there is a price table, and their number otherwise.
+//
+// The rows of a stacked mosaic are layers of pieces seen from their side, so only the parts one
+// stud wide are used, lying along the rows.
+func (c *Conversion) mergeParts(parts []Part, prices PriceTable) {
+	if c.Stacked {
+		var layer []Part
+		for _, p := range parts {
+			if p.Width == 1 {
+				layer = append(layer, p)
+			}
+		}
+		parts = layer
+	}
+	c.Placements = mergeStuds(c.colors, c.grid, parts, prices, !c.Stacked)
+	c.BillOfMaterials = bomFromPlacements(c.Placements)
+	c.PiecesUsed = len(c.Placements)
+}
+
+// writePlacementsCSV writes the parts placed on the mosaic as a CSV file with the header
+// part,size,legoid,name,x,y,rotation.
+//
+// When layers is set the mosaic is stacked with that many layers, so the header is
+// part,size,legoid,name,x,layer,rotation instead, the layers being counted from the bottom one
+// as in the building map, and the parts are listed layer by layer from the bottom.
+func writePlacementsCSV(w io.Writer, placements []Placement, layers int) error {
+	row, column := func(p Placement) int { return p.Y }, "y"
+	if layers > 0 {
+		row, column = func(p Placement) int { return layers - p.Y }, "layer"
+		placements = append([]Placement(nil), placements...)
+		sort.SliceStable(placements, func(i, j int) bool {
+			return row(placements[i]) < row(placements[j])
+		})
+	}
+
+	csvWriter := csv.NewWriter(w)
+	_ = csvWriter.Write([]string{"part", "size", "legoid", "name", "x", column, "rotation"})
+	for _, p := range placements {
+		_ = csvWriter.Write([]string{
+			p.Part.ID,
+			p.Part.String(),
+			strconv.Itoa(p.Color.LegoID),
+			p.Color.Name,
+			strconv.Itoa(p.X),
+			strconv.Itoa(row(p)),
+			strconv.Itoa(p.Rotation),
+		})
+	}
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return fmt.Errorf("writing placements: err=%v", err)
+	}
+	return nil
+}
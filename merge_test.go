@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_partsBySize(t *testing.T) {
+	parts, err := partsBySize(plateParts, []string{"1x1", "4x2", "1x2"})
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	var ids []string
+	for _, p := range parts {
+		ids = append(ids, p.ID)
+	}
+	if want := []string{"3024", "3020", "3023"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("wrong parts: got=%v, expected=%v", ids, want)
+	}
+
+	for _, sizes := range [][]string{{"1x2", "2x2"}, {"1x1", "3x3"}, {"1x1", "2x"}, {"1x1", "2x4x"}} {
+		if _, err := partsBySize(plateParts, sizes); err == nil {
+			t.Errorf("invalid sizes should return an error: sizes=%q", sizes)
+		}
+	}
+}
+
+func Test_mergeStuds(t *testing.T) {
+	colors := []LegoColor{{LegoID: 0, Name: "Black"}, {LegoID: 15, Name: "White"}}
+	parts, _ := partsBySize(plateParts, []string{"1x1", "1x2", "2x2", "2x4"})
+
+	tests := []struct {
+		name string
+		// grid is indexed as grid[x][y]
+		grid [][]int
+		want []Placement
+	}{
+		{
+			name: "horizontal",
+			grid: [][]int{{0, 0}, {0, 0}, {0, 0}, {0, 0}},
+			want: []Placement{{Part: parts[3], Color: colors[0]}},
+		},
+		{
+			name: "vertical",
+			grid: [][]int{{1, 1, 1, 1}, {1, 1, 1, 1}},
+			want: []Placement{{Part: parts[3], Color: colors[1], Rotation: 90}},
+		},
+		{
+			name: "mixed",
+			grid: [][]int{{0, 0, 1}, {0, 0, 1}, {1, -1, 0}},
+			want: []Placement{
+				{Part: parts[2], Color: colors[0]},
+				{Part: parts[0], Color: colors[1], X: 2},
+				{Part: parts[1], Color: colors[1], Y: 2},
+				{Part: parts[0], Color: colors[0], X: 2, Y: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeStuds(colors, tt.grid, parts, nil, true); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrong placements: got=%+v, expected=%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConversionMergeParts(t *testing.T) {
+	img := benchmarkImage(48, 32)
+	lego := Lego{colors: defaultColors, maxColors: 6}
+	conversion, err := lego.mapFromImage(context.Background(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	studs := conversion.PiecesUsed
+	parts, _ := partsBySize(plateParts, splitList(defaultMergeParts))
+	conversion.mergeParts(parts, nil)
+
+	// every stud is covered by a single part of its color
+	covered := make(map[[2]int]bool)
+	for _, p := range conversion.Placements {
+		w, h := p.size()
+		for x := p.X; x < p.X+w; x++ {
+			for y := p.Y; y < p.Y+h; y++ {
+				if covered[[2]int{x, y}] {
+					t.Fatalf("stud covered twice: x=%d, y=%d", x, y)
+				}
+				covered[[2]int{x, y}] = true
+				if c := conversion.colors[conversion.grid[x][y]]; c != p.Color {
+					t.Fatalf("wrong color: x=%d, y=%d, got=%s, expected=%s", x, y, p.Color.Name, c.Name)
+				}
+			}
+		}
+	}
+	if len(covered) != studs {
+		t.Errorf("wrong studs covered: got=%d, expected=%d", len(covered), studs)
+	}
+	if conversion.PiecesUsed >= studs || conversion.PiecesUsed != len(conversion.Placements) {
+		t.Errorf("wrong pieces used: got=%d, studs=%d", conversion.PiecesUsed, studs)
+	}
+
+	var total int
+	for _, e := range conversion.BillOfMaterials {
+		total += e.Count
+	}
+	if total != conversion.PiecesUsed {
+		t.Errorf("wrong bill of materials: pieces=%d, expected=%d", total, conversion.PiecesUsed)
+	}
+}
+
+func Test_writePlacementsCSV(t *testing.T) {
+	red := LegoColor{LegoID: 4, Name: "Red"}
+	placements := []Placement{
+		{Part: plateParts[0], Color: red, X: 0, Y: 0},
+		{Part: plateParts[1], Color: red, X: 1, Y: 2},
+	}
+
+	var buf strings.Builder
+	if err := writePlacementsCSV(&buf, placements, 0); err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	want := "part,size,legoid,name,x,y,rotation\n3024,1x1,4,Red,0,0,0\n3023,1x2,4,Red,1,2,0\n"
+	if buf.String() != want {
+		t.Errorf("wrong placements: got=%q, expected=%q", buf.String(), want)
+	}
+
+	// the layers of a stacked mosaic are counted from the bottom, listed from the bottom one
+	buf.Reset()
+	if err := writePlacementsCSV(&buf, placements, 3); err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	want = "part,size,legoid,name,x,layer,rotation\n3023,1x2,4,Red,1,1,0\n3024,1x1,4,Red,0,3,0\n"
+	if buf.String() != want {
+		t.Errorf("wrong stacked placements: got=%q, expected=%q", buf.String(), want)
+	}
+}
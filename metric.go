@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// ColorVec is a color projected into the space in which a ColorMetric measures distances.
+type ColorVec [3]float64
+
+// ColorMetric measures how close a pixel is to a palette entry.
+//
+// Colors are projected into the metric's own color space before being compared, which allows
+// projecting every palette entry only once and propagating dithering errors in that same space.
+type ColorMetric interface {
+	// Project converts a pixel into the metric's color space.
+	Project(p Pixel) ColorVec
+	// Distance returns how far the pixel is from the palette entry, both already projected.
+	Distance(pixel, entry ColorVec) float64
+}
+
+// PalettePrecomputer can optionally be implemented by a ColorMetric that needs to inspect the
+// palette before being used. Precompute is called once per palette, before any Distance call.
+type PalettePrecomputer interface {
+	Precompute(palette []LegoColor)
+}
+
+// Names of the metrics registered by default.
+const (
+	metricRGB       = "rgb"
+	metricRedmean   = "redmean"
+	metricOklab     = "oklab"
+	metricCIE76     = "cie76"
+	metricCIE94     = "cie94"
+	metricCIEDE2000 = "ciede2000"
+)
+
+var (
+	metricsMu sync.RWMutex
+	metrics   = make(map[string]func() ColorMetric)
+)
+
+func init() {
+	RegisterMetric(metricRGB, func() ColorMetric { return rgbMetric{} })
+	RegisterMetric(metricRedmean, func() ColorMetric { return redmeanMetric{} })
+	RegisterMetric(metricOklab, func() ColorMetric { return oklabMetric{} })
+	RegisterMetric(metricCIE76, func() ColorMetric { return labMetric{deltaE: deltaE76, euclidean: true} })
+	RegisterMetric(metricCIE94, func() ColorMetric { return labMetric{deltaE: deltaE94} })
+	RegisterMetric(metricCIEDE2000, func() ColorMetric { return labMetric{deltaE: deltaE2000} })
+}
+
+// RegisterMetric makes a color metric available by name. A new metric is built through the
+// factory for every conversion, so metrics that precompute palette data don't share state.
+// Registering an existing name replaces the previous metric.
+func RegisterMetric(name string, factory func() ColorMetric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics[name] = factory
+}
+
+// MetricByName returns a new instance of the metric registered under the given name.
+func MetricByName(name string) (ColorMetric, error) {
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+	factory, ok := metrics[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown color metric: metric=%q", name)
+	}
+	return factory(), nil
+}
+
+// MetricNames returns the names of all the registered metrics, sorted.
+func MetricNames() []string {
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// projectPalette projects every palette entry into the metric's space,
+// running the metric's precompute hook first if it has one.
+func projectPalette(metric ColorMetric, palette []LegoColor) []ColorVec {
+	if p, ok := metric.(PalettePrecomputer); ok {
+		p.Precompute(palette)
+	}
+	projected := make([]ColorVec, len(palette))
+	for i, c := range palette {
+		projected[i] = metric.Project(Pixel{R: c.R, G: c.G, B: c.B})
+	}
+	return projected
+}
+
+// euclidean returns the euclidean distance between two projected colors.
+func euclidean(a, b ColorVec) float64 {
+	d0, d1, d2 := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return math.Sqrt(d0*d0 + d1*d1 + d2*d2)
+}
+
+// rgbMetric is the weighted euclidean distance of calculateDistance, with the
+// weights applied on projection.
+type rgbMetric struct{}
+
+func (rgbMetric) Project(p Pixel) ColorVec {
+	return ColorVec{float64(p.R) * 0.30, float64(p.G) * 0.59, float64(p.B) * 0.11}
+}
+
+func (rgbMetric) Distance(pixel, entry ColorVec) float64 {
+	return euclidean(pixel, entry)
+}
+
+func (rgbMetric) IsEuclidean() bool { return true }
+
+// redmeanMetric is a low cost approximation of perceptual distance in RGB.
+// Reference: https://www.compuphase.com/cmetric.htm
+type redmeanMetric struct{}
+
+func (redmeanMetric) Project(p Pixel) ColorVec {
+	return ColorVec{float64(p.R), float64(p.G), float64(p.B)}
+}
+
+func (redmeanMetric) Distance(pixel, entry ColorVec) float64 {
+	rmean := (pixel[0] + entry[0]) / 2
+	dR, dG, dB := pixel[0]-entry[0], pixel[1]-entry[1], pixel[2]-entry[2]
+	return math.Sqrt((2+rmean/256)*dR*dR + 4*dG*dG + (2+(255-rmean)/256)*dB*dB)
+}
+
+// oklabMetric is the euclidean distance in the Oklab color space.
+// Reference: https://bottosson.github.io/posts/oklab/
+type oklabMetric struct{}
+
+func (oklabMetric) Project(p Pixel) ColorVec {
+	r, g, b := srgbToLinear[clamp8(p.R)], srgbToLinear[clamp8(p.G)], srgbToLinear[clamp8(p.B)]
+
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+
+	return ColorVec{
+		0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+func (oklabMetric) Distance(pixel, entry ColorVec) float64 {
+	return euclidean(pixel, entry)
+}
+
+func (oklabMetric) IsEuclidean() bool { return true }
+
+// labMetric compares colors in CIELAB with one of the CIE delta E formulas.
+type labMetric struct {
+	deltaE func(c1, c2 Lab) float64
+	// euclidean is set for CIE76, which is the plain euclidean distance in CIELAB
+	euclidean bool
+}
+
+func (labMetric) Project(p Pixel) ColorVec {
+	lab := labFromRGB(p.R, p.G, p.B)
+	return ColorVec{lab.L, lab.A, lab.B}
+}
+
+func (m labMetric) Distance(pixel, entry ColorVec) float64 {
+	// the palette entry is the reference color for the asymmetric formulas (CIE94)
+	return m.deltaE(Lab{L: entry[0], A: entry[1], B: entry[2]}, Lab{L: pixel[0], A: pixel[1], B: pixel[2]})
+}
+
+func (m labMetric) IsEuclidean() bool { return m.euclidean }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+type lightnessMetric struct{}
+
+func (lightnessMetric) Project(p Pixel) ColorVec {
+	return ColorVec{float64(p.R+p.G+p.B) / 3}
+}
+
+func (lightnessMetric) Distance(pixel, entry ColorVec) float64 {
+	return math.Abs(pixel[0] - entry[0])
+}
+
+func TestMetricByName(t *testing.T) {
+	RegisterMetric("test-lightness", func() ColorMetric { return lightnessMetric{} })
+
+	for _, name := range []string{metricRGB, metricRedmean, metricOklab, metricCIE76, metricCIE94, metricCIEDE2000, "test-lightness"} {
+		if _, err := MetricByName(name); err != nil {
+			t.Errorf("metric should be registered: name=%q, err=%v", name, err)
+		}
+	}
+
+	if _, err := MetricByName("unknown"); err == nil {
+		t.Errorf("an unknown metric should return an error")
+	}
+}
+
+func Test_rgbMetric(t *testing.T) {
+	m := rgbMetric{}
+	for _, p := range legocolors[:20] {
+		q := Pixel{R: 255, G: 0, B: 0}
+		got := m.Distance(m.Project(q), m.Project(p))
+		if want := calculateDistance(p, q); math.Abs(got-want) > 1e-9 {
+			t.Errorf("rgb metric should match calculateDistance: pixel=%v, got=%f, expected=%f", p, got, want)
+		}
+	}
+}
+
+func TestMapFromImageMetrics(t *testing.T) {
+	colors := []LegoColor{
+		{LegoID: 0, Name: "Black", R: 5, G: 19, B: 29},
+		{LegoID: 15, Name: "White", R: 255, G: 255, B: 255},
+		{LegoID: 4, Name: "Red", R: 201, G: 26, B: 9},
+		{LegoID: 1, Name: "Blue", R: 0, G: 85, B: 191},
+	}
+	img := newUniformRGBA(2, 2, Pixel{R: 10, G: 60, B: 210})
+
+	for _, name := range []string{metricRGB, metricRedmean, metricOklab, metricCIE76, metricCIE94, metricCIEDE2000} {
+		metric, _ := MetricByName(name)
+		t.Run(name, func(t *testing.T) {
+			lego := Lego{colors: colors, metric: metric}
+			conversion, err := lego.mapFromImage(context.Background(), img)
+			if err != nil {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if got := conversion.Image.RGBAAt(0, 0); got.R != 0 || got.G != 85 || got.B != 191 {
+				t.Errorf("wrong color found as closest: found=%v, expected Blue", got)
+			}
+		})
+	}
+}
+
+func newUniformRGBA(x, y int, p Pixel) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, x, y))
+	for i := 0; i < x; i++ {
+		for j := 0; j < y; j++ {
+			img.SetRGBA(i, j, color.RGBA{R: uint8(p.R), G: uint8(p.G), B: uint8(p.B), A: 255})
+		}
+	}
+	return img
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Supported orientations of the pieces.
+const (
+	orientationStudsUp      = "studs-up"
+	orientationStackedBrick = "stacked-brick"
+	orientationStackedPlate = "stacked-plate"
+)
+
+// stackedPieces are the pieces every stacked orientation is built with.
+var stackedPieces = map[string]string{
+	orientationStackedBrick: pieceBrick,
+	orientationStackedPlate: piecePlate,
+}
+
+// Orientations returns the names of the supported orientations.
+func Orientations() []string {
+	return []string{orientationStudsUp, orientationStackedBrick, orientationStackedPlate}
+}
+
+// isStacked tells if the pieces of the orientation are stacked with their side facing the viewer.
+func isStacked(orientation string) bool {
+	_, ok := stackedPieces[orientation]
+	return ok
+}
+
+// pieceForOrientation returns the piece the mosaic is built with in the given orientation.
+// Mosaics with the studs up can be built with any piece, the plate by default, while stacked
+// mosaics are built with the side of the pieces facing the viewer, every row being a layer of
+// bricks or plates stacked on the one below.
+func pieceForOrientation(orientation, name string) (Piece, error) {
+	switch orientation {
+	case "", orientationStudsUp:
+		if name == "" {
+			return defaultPiece, nil
+		}
+		return PieceByName(name)
+	case orientationStackedBrick, orientationStackedPlate:
+		stacked := stackedPieces[orientation]
+		if name != "" && name != stacked {
+			return Piece{}, fmt.Errorf("the %s orientation is built with the %s piece: piece=%q", orientation, stacked, name)
+		}
+		return pieces[stacked], nil
+	}
+	return Piece{}, fmt.Errorf("unknown orientation: orientation=%q", orientation)
+}
+
+// stackedAspect returns the height of the side of the piece divided by its width, the shape of
+// every cell of a stacked mosaic: 6/5 for bricks and 2/5 for plates.
+func (p Piece) stackedAspect() float64 {
+	return p.Height / studPitchMM
+}
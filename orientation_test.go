@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"image"
+	"strings"
+	"testing"
+)
+
+func Test_pieceForOrientation(t *testing.T) {
+	tests := []struct {
+		orientation string
+		piece       string
+		want        string
+		wantErr     bool
+	}{
+		{orientation: "", piece: "", want: "3024"},
+		{orientation: orientationStudsUp, piece: pieceRoundTile, want: "98138"},
+		{orientation: orientationStackedBrick, piece: "", want: "3005"},
+		{orientation: orientationStackedBrick, piece: pieceBrick, want: "3005"},
+		{orientation: orientationStackedPlate, piece: "", want: "3024"},
+		{orientation: orientationStackedPlate, piece: pieceTile, wantErr: true},
+		{orientation: "sideways", piece: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.orientation+"/"+tt.piece, func(t *testing.T) {
+			got, err := pieceForOrientation(tt.orientation, tt.piece)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if got.PartID != tt.want {
+				t.Errorf("wrong piece: got=%q, expected=%q", got.PartID, tt.want)
+			}
+		})
+	}
+}
+
+func TestStackedConversion(t *testing.T) {
+	palette := []LegoColor{{LegoID: 4, Name: "Red", R: 201, G: 26, B: 9}}
+	lego := Lego{colors: palette, piece: pieces[pieceBrick], stacked: true}
+	conversion, err := lego.mapFromImage(context.Background(), newUniformRGBA(2, 3, Pixel{R: 201, G: 26, B: 9}))
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+
+	// the bottom row is the first layer
+	if got := conversion.BuildMap[1][2]; !strings.HasPrefix(got, "[layer 1][1] = ") {
+		t.Errorf("wrong build map: got=%q", got)
+	}
+	if got := conversion.BuildMap[0][0]; !strings.HasPrefix(got, "[layer 3][0] = ") {
+		t.Errorf("wrong build map: got=%q", got)
+	}
+
+	// bricks are drawn 5:6, at least 5 pixels wide
+	if got, want := conversion.preview(1).Bounds(), image.Rect(0, 0, 10, 18); got != want {
+		t.Errorf("wrong preview size: got=%v, expected=%v", got, want)
+	}
+	if got, want := conversion.preview(10).Bounds(), image.Rect(0, 0, 20, 36); got != want {
+		t.Errorf("wrong preview size: got=%v, expected=%v", got, want)
+	}
+
+	// the parts lie along the layers
+	conversion.mergeParts(brickParts, nil)
+	for _, p := range conversion.Placements {
+		if p.Rotation != 0 || p.Part.Width != 1 {
+			t.Errorf("wrong placement in a stacked mosaic: got=%+v", p)
+		}
+	}
+	if len(conversion.Placements) != 3 {
+		t.Errorf("wrong placements: got=%d, expected=3", len(conversion.Placements))
+	}
+}
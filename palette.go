@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Color categories, the families and materials a color can belong to.
+const (
+	categoryTrans    = "trans"
+	categoryChrome   = "chrome"
+	categoryGlitter  = "glitter"
+	categorySpeckle  = "speckle"
+	categoryPearl    = "pearl"
+	categoryMetallic = "metallic"
+	categoryModulex  = "modulex"
+	categoryGlow     = "glow"
+	categoryOpal     = "opal"
+	// categorySolid holds the colors that don't belong to any of the other categories
+	categorySolid = "solid"
+)
+
+// ColorCategories returns the names of all the color categories.
+func ColorCategories() []string {
+	return []string{
+		categoryTrans, categoryChrome, categoryGlitter, categorySpeckle, categoryPearl,
+		categoryMetallic, categoryModulex, categoryGlow, categoryOpal, categorySolid,
+	}
+}
+
+// Categories returns the families and materials of the color, told by its name as both the
+// Rebrickable and BrickLink names start with them, e.g. "Chrome Gold" or "Glitter Trans-Clear".
+func (c LegoColor) Categories() []string {
+	var categories []string
+	add := func(category string, ok bool) {
+		if ok {
+			categories = append(categories, category)
+		}
+	}
+	add(categoryTrans, c.IsTrans || isTransName(c.Name))
+	add(categoryChrome, strings.HasPrefix(c.Name, "Chrome"))
+	add(categoryGlitter, strings.HasPrefix(c.Name, "Glitter"))
+	add(categorySpeckle, strings.HasPrefix(c.Name, "Speckle"))
+	add(categoryPearl, strings.HasPrefix(c.Name, "Pearl"))
+	add(categoryMetallic, strings.HasPrefix(c.Name, "Metallic") || strings.HasPrefix(c.Name, "Metal ") ||
+		strings.HasPrefix(c.Name, "Flat ") || c.Name == "Copper")
+	add(categoryModulex, strings.HasPrefix(c.Name, "Modulex"))
+	add(categoryGlow, strings.Contains(c.Name, "Glow"))
+	add(categoryOpal, strings.Contains(c.Name, "Opal"))
+	if len(categories) == 0 {
+		categories = append(categories, categorySolid)
+	}
+	return categories
+}
+
+// inCategory tells if the color belongs to any of the given categories.
+func (c LegoColor) inCategory(categories []string) bool {
+	for _, have := range c.Categories() {
+		for _, want := range categories {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PaletteFilter selects the colors of a palette that can be used for a mosaic. A color is kept when
+// it passes every filter set; the include filters keep only the colors matching them, and the exclude
+// filters drop the colors matching them.
+type PaletteFilter struct {
+	IncludeCategories []string
+	ExcludeCategories []string
+	IncludeNames      *regexp.Regexp
+	ExcludeNames      *regexp.Regexp
+	IncludeIDs        []int
+	ExcludeIDs        []int
+	// SolidOnly keeps only the solid colors, it's the same as including the solid category.
+	SolidOnly bool
+}
+
+func (f PaletteFilter) validate() error {
+	known := make(map[string]bool)
+	for _, category := range ColorCategories() {
+		known[category] = true
+	}
+	for _, category := range append(append([]string{}, f.IncludeCategories...), f.ExcludeCategories...) {
+		if !known[category] {
+			return fmt.Errorf("unknown color category, expected one of %s: category=%q", strings.Join(ColorCategories(), ", "), category)
+		}
+	}
+	return nil
+}
+
+// Apply returns the colors of the palette that pass the filter, in the same order.
+func (f PaletteFilter) Apply(colors []LegoColor) ([]LegoColor, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+
+	include := f.IncludeCategories
+	if f.SolidOnly {
+		include = append(append([]string{}, include...), categorySolid)
+	}
+
+	var filtered []LegoColor
+	for _, c := range colors {
+		switch {
+		case len(include) > 0 && !c.inCategory(include):
+		case c.inCategory(f.ExcludeCategories):
+		case f.IncludeNames != nil && !f.IncludeNames.MatchString(c.Name):
+		case f.ExcludeNames != nil && f.ExcludeNames.MatchString(c.Name):
+		case len(f.IncludeIDs) > 0 && !containsInt(f.IncludeIDs, c.LegoID):
+		case containsInt(f.ExcludeIDs, c.LegoID):
+		default:
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, ignoring the empty values.
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// parseIDList parses a comma separated list of color IDs.
+func parseIDList(list string) ([]int, error) {
+	var ids []int
+	for _, v := range splitList(list) {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid color ID: id=%q", v)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// compileOptional compiles the regular expression, returning nil when it's empty.
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: expr=%q, err=%v", expr, err)
+	}
+	return re, nil
+}
+
+// paletteFilterFromFlags builds the palette filter from the command line flags.
+func paletteFilterFromFlags(flags *Flags) (PaletteFilter, error) {
+	f := PaletteFilter{
+		IncludeCategories: splitList(flags.Include),
+		ExcludeCategories: splitList(flags.Exclude),
+		SolidOnly:         flags.SolidOnly,
+	}
+	var err error
+	if f.IncludeNames, err = compileOptional(flags.IncludeNames); err != nil {
+		return f, err
+	}
+	if f.ExcludeNames, err = compileOptional(flags.ExcludeNames); err != nil {
+		return f, err
+	}
+	if f.IncludeIDs, err = parseIDList(flags.IncludeIDs); err != nil {
+		return f, err
+	}
+	if f.ExcludeIDs, err = parseIDList(flags.ExcludeIDs); err != nil {
+		return f, err
+	}
+	return f, f.validate()
+}
+
+// ColorGroup is a set of palette colors sharing the same RGB value, of which only Kept is used.
+type ColorGroup struct {
+	Kept    LegoColor
+	Dropped []LegoColor
+}
+
+// finishRank orders the colors by how easy they are to find, lower first: solid colors are the
+// most common, followed by transparent ones, and the Modulex colors are the hardest to find.
+func finishRank(c LegoColor) int {
+	rank := 0
+	for _, category := range c.Categories() {
+		r := 0
+		switch category {
+		case categoryTrans:
+			r = 1
+		case categoryPearl, categoryMetallic:
+			r = 2
+		case categoryChrome, categoryGlitter, categorySpeckle, categoryGlow, categoryOpal:
+			r = 3
+		case categoryModulex:
+			r = 4
+		}
+		if r > rank {
+			rank = r
+		}
+	}
+	return rank
+}
+
+// dedupePalette keeps a single color of every group of colors sharing the same RGB value, as only
+// one of them can ever be picked. The kept color is the first one found in prefer, a list of color
+// IDs, or else the most common one, telling apart colors equally common by the lowest ID. The kept
+// color takes the place of the first color of its group, and the groups of more than one color are
+// returned in that same order.
+func dedupePalette(colors []LegoColor, prefer []int) ([]LegoColor, []ColorGroup) {
+	preference := make(map[int]int, len(prefer))
+	for i, id := range prefer {
+		if _, ok := preference[id]; !ok {
+			preference[id] = i
+		}
+	}
+	better := func(a, b LegoColor) bool {
+		pa, aPreferred := preference[a.LegoID]
+		pb, bPreferred := preference[b.LegoID]
+		switch {
+		case aPreferred && bPreferred:
+			return pa < pb
+		case aPreferred != bPreferred:
+			return aPreferred
+		}
+		if ra, rb := finishRank(a), finishRank(b); ra != rb {
+			return ra < rb
+		}
+		return a.LegoID < b.LegoID
+	}
+
+	type rgb struct{ r, g, b int }
+	var order []rgb
+	members := make(map[rgb][]LegoColor)
+	for _, c := range colors {
+		key := rgb{c.R, c.G, c.B}
+		if _, ok := members[key]; !ok {
+			order = append(order, key)
+		}
+		members[key] = append(members[key], c)
+	}
+
+	deduped := make([]LegoColor, 0, len(order))
+	var groups []ColorGroup
+	for _, key := range order {
+		group := members[key]
+		kept := 0
+		for i := range group {
+			if better(group[i], group[kept]) {
+				kept = i
+			}
+		}
+		deduped = append(deduped, group[kept])
+		if len(group) == 1 {
+			continue
+		}
+
+		dropped := make([]LegoColor, 0, len(group)-1)
+		for i, c := range group {
+			if i != kept {
+				dropped = append(dropped, c)
+			}
+		}
+		groups = append(groups, ColorGroup{Kept: group[kept], Dropped: dropped})
+	}
+	return deduped, groups
+}
+
+// String describes the group as "White (15) instead of Glitter Trans-Clear (117), Modulex Clear (1039)".
+func (g ColorGroup) String() string {
+	names := make([]string, len(g.Dropped))
+	for i, c := range g.Dropped {
+		names[i] = fmt.Sprintf("%s (%d)", c.Name, c.LegoID)
+	}
+	return fmt.Sprintf("%s (%d) instead of %s", g.Kept.Name, g.Kept.LegoID, strings.Join(names, ", "))
+}
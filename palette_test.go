@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestLegoColorCategories(t *testing.T) {
+	tests := []struct {
+		name  string
+		color LegoColor
+		want  []string
+	}{
+		{name: "solid", color: LegoColor{Name: "Red"}, want: []string{categorySolid}},
+		{name: "trans by flag", color: LegoColor{Name: "Clear", IsTrans: true}, want: []string{categoryTrans}},
+		{name: "glitter trans", color: LegoColor{Name: "Glitter Trans-Clear"}, want: []string{categoryTrans, categoryGlitter}},
+		{name: "chrome", color: LegoColor{Name: "Chrome Gold"}, want: []string{categoryChrome}},
+		{name: "metallic", color: LegoColor{Name: "Flat Silver"}, want: []string{categoryMetallic}},
+		{name: "modulex", color: LegoColor{Name: "Modulex Black"}, want: []string{categoryModulex}},
+		{name: "glow", color: LegoColor{Name: "Glow In Dark Trans"}, want: []string{categoryTrans, categoryGlow}},
+		{name: "opal", color: LegoColor{Name: "Trans-Blue Opal"}, want: []string{categoryTrans, categoryOpal}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.color.Categories(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrong categories: got=%v, expected=%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaletteFilterApply(t *testing.T) {
+	palette := []LegoColor{
+		{LegoID: 0, Name: "Black"},
+		{LegoID: 4, Name: "Red"},
+		{LegoID: 47, Name: "Trans-Clear", IsTrans: true},
+		{LegoID: 383, Name: "Chrome Silver"},
+		{LegoID: 1018, Name: "Modulex Black"},
+		{LegoID: 297, Name: "Pearl Gold"},
+	}
+	tests := []struct {
+		name    string
+		filter  PaletteFilter
+		wantIDs []int
+		wantErr bool
+	}{
+		{name: "no filter", wantIDs: []int{0, 4, 47, 383, 1018, 297}},
+		{name: "exclude categories", filter: PaletteFilter{ExcludeCategories: []string{"trans", "chrome", "modulex"}}, wantIDs: []int{0, 4, 297}},
+		{name: "include categories", filter: PaletteFilter{IncludeCategories: []string{"pearl", "chrome"}}, wantIDs: []int{383, 297}},
+		{name: "solid only", filter: PaletteFilter{SolidOnly: true}, wantIDs: []int{0, 4}},
+		{name: "include names", filter: PaletteFilter{IncludeNames: regexp.MustCompile("Black$")}, wantIDs: []int{0, 1018}},
+		{name: "exclude names", filter: PaletteFilter{ExcludeNames: regexp.MustCompile("(?i)gold|silver")}, wantIDs: []int{0, 4, 47, 1018}},
+		{name: "include IDs", filter: PaletteFilter{IncludeIDs: []int{4, 47}}, wantIDs: []int{4, 47}},
+		{name: "exclude IDs", filter: PaletteFilter{SolidOnly: true, ExcludeIDs: []int{0}}, wantIDs: []int{4}},
+		{name: "unknown category", filter: PaletteFilter{ExcludeCategories: []string{"wood"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.Apply(palette)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			var ids []int
+			for _, c := range got {
+				ids = append(ids, c.LegoID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("wrong colors: got=%v, expected=%v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func Test_parseIDList(t *testing.T) {
+	if ids, err := parseIDList(" 1, 4,,71 "); err != nil || !reflect.DeepEqual(ids, []int{1, 4, 71}) {
+		t.Errorf("wrong IDs parsed: ids=%v, err=%v", ids, err)
+	}
+	if _, err := parseIDList("1,red"); err == nil {
+		t.Errorf("invalid IDs should return an error")
+	}
+}
+
+func Test_dedupePalette(t *testing.T) {
+	palette := []LegoColor{
+		{LegoID: 132, Name: "Speckle Black-Silver", Hex: "05131D", R: 5, G: 19, B: 29},
+		{LegoID: 4, Name: "Red", Hex: "C91A09", R: 201, G: 26, B: 9},
+		{LegoID: 0, Name: "Black", Hex: "05131D", R: 5, G: 19, B: 29},
+		{LegoID: 75, Name: "Speckle Black-Copper", Hex: "05131D", R: 5, G: 19, B: 29},
+		{LegoID: 1039, Name: "Modulex Clear", Hex: "FFFFFF", R: 255, G: 255, B: 255},
+		{LegoID: 117, Name: "Glitter Trans-Clear", Hex: "FFFFFF", R: 255, G: 255, B: 255},
+	}
+	tests := []struct {
+		name       string
+		prefer     []int
+		wantIDs    []int
+		wantGroups []string
+	}{
+		{
+			name:    "by commonality",
+			wantIDs: []int{0, 4, 117},
+			wantGroups: []string{
+				"Black (0) instead of Speckle Black-Silver (132), Speckle Black-Copper (75)",
+				"Glitter Trans-Clear (117) instead of Modulex Clear (1039)",
+			},
+		},
+		{
+			name:    "by preference",
+			prefer:  []int{75, 1039, 132},
+			wantIDs: []int{75, 4, 1039},
+			wantGroups: []string{
+				"Speckle Black-Copper (75) instead of Speckle Black-Silver (132), Black (0)",
+				"Modulex Clear (1039) instead of Glitter Trans-Clear (117)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deduped, groups := dedupePalette(palette, tt.prefer)
+			var ids []int
+			for _, c := range deduped {
+				ids = append(ids, c.LegoID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("wrong colors: got=%v, expected=%v", ids, tt.wantIDs)
+			}
+			var descriptions []string
+			for _, g := range groups {
+				descriptions = append(descriptions, g.String())
+			}
+			if !reflect.DeepEqual(descriptions, tt.wantGroups) {
+				t.Errorf("wrong groups: got=%q, expected=%q", descriptions, tt.wantGroups)
+			}
+		})
+	}
+}
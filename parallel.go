@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// PartialConversionError is returned by mapFromImage when the context is done before the
+// conversion finishes, together with a conversion holding only the rows completed so far.
+type PartialConversionError struct {
+	RowsDone  int
+	RowsTotal int
+	Err       error
+}
+
+func (e *PartialConversionError) Error() string {
+	return fmt.Sprintf("conversion interrupted after %d of %d rows: err=%v", e.RowsDone, e.RowsTotal, e.Err)
+}
+
+func (e *PartialConversionError) Unwrap() error {
+	return e.Err
+}
+
+// workerCount returns the number of workers to use, one per CPU when not set.
+func workerCount(workers int) int {
+	if workers < 1 {
+		return runtime.NumCPU()
+	}
+	return workers
+}
+
+// bandsPerWorker splits the image in more bands than workers, so a slow band doesn't leave
+// the rest of the workers idle at the end of the conversion.
+const bandsPerWorker = 4
+
+// mapBands sets grid[x][y] = fn(x, y) for every pixel, splitting the rows in bands processed
+// by a pool of workers. Pixels are independent from each other, so the result doesn't depend
+// on the number of workers. When the context is done the remaining bands are skipped, and the
+// number of rows completed is returned along with the context error.
+func mapBands(ctx context.Context, workers int, grid [][]int, height int, fn func(x, y int) int) (int, error) {
+	workers = workerCount(workers)
+	bandHeight := height / (workers * bandsPerWorker)
+	if bandHeight < 1 {
+		bandHeight = 1
+	}
+
+	bands := make(chan int)
+	go func() {
+		defer close(bands)
+		for y := 0; y < height; y += bandHeight {
+			select {
+			case bands <- y:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var rowsDone int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range bands {
+				for y := start; y < start+bandHeight && y < height; y++ {
+					if ctx.Err() != nil {
+						return
+					}
+					for x := range grid {
+						grid[x][y] = fn(x, y)
+					}
+					atomic.AddInt64(&rowsDone, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return int(rowsDone), ctx.Err()
+}
+
+// wavefront schedules rows that depend on the rows above them, as error diffusion does: every
+// row is processed by a single worker, which waits until the previous row has gone far enough
+// for the pixel it is about to process.
+type wavefront struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// progress holds how many pixels of every row have been processed
+	progress []int64
+}
+
+// wavefrontNotifyEvery is how many pixels a row processes between wake ups of the row below.
+const wavefrontNotifyEvery = 8
+
+func newWavefront(height int) *wavefront {
+	w := &wavefront{progress: make([]int64, height)}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// run calls fn(y) for every row, rows being handed to the workers in order.
+// fn reports its progress with advance and waits for the previous row with wait.
+func (w *wavefront) run(ctx context.Context, workers int, fn func(y int)) (int, error) {
+	// wake up the waiting workers when the context is done, so they can leave
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.broadcast()
+		case <-done:
+		}
+	}()
+
+	rows := make(chan int)
+	go func() {
+		defer close(rows)
+		for y := range w.progress {
+			select {
+			case rows <- y:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var rowsDone int64
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount(workers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := range rows {
+				if ctx.Err() != nil {
+					return
+				}
+				fn(y)
+				if ctx.Err() != nil {
+					return
+				}
+				atomic.AddInt64(&rowsDone, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return int(rowsDone), ctx.Err()
+}
+
+// advance records that row y has processed n pixels.
+func (w *wavefront) advance(y, n int, last bool) {
+	atomic.StoreInt64(&w.progress[y], int64(n))
+	if last || n%wavefrontNotifyEvery == 0 {
+		w.broadcast()
+	}
+}
+
+// wait blocks until row y has processed at least n pixels, returning false if the context is done first.
+func (w *wavefront) wait(ctx context.Context, y, n int) bool {
+	if atomic.LoadInt64(&w.progress[y]) >= int64(n) {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for atomic.LoadInt64(&w.progress[y]) < int64(n) {
+		if ctx.Err() != nil {
+			return false
+		}
+		w.cond.Wait()
+	}
+	return true
+}
+
+func (w *wavefront) broadcast() {
+	w.mu.Lock()
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
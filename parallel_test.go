@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMapFromImageWorkers(t *testing.T) {
+	img := benchmarkImage(61, 47)
+	metric, _ := MetricByName(metricOklab)
+
+	ditherings := []Dithering{
+		{},
+		{Mode: ditherBayer4, Strength: 1},
+		{Mode: ditherFloydSteinberg, Strength: 1},
+		{Mode: ditherFloydSteinberg, Strength: 1, Serpentine: true},
+		{Mode: ditherJJN, Strength: 0.8},
+		{Mode: ditherAtkinson, Strength: 1, Serpentine: true},
+	}
+	for _, d := range ditherings {
+		t.Run(fmt.Sprintf("%s/serpentine=%t", d.Mode, d.Serpentine), func(t *testing.T) {
+			var want [][]string
+			for _, workers := range []int{1, 2, 3, 8} {
+				lego := Lego{colors: defaultColors, metric: metric, dither: d, workers: workers}
+				conversion, err := lego.mapFromImage(context.Background(), img)
+				if err != nil {
+					t.Fatalf("unexpected error: err=%v", err)
+				}
+				if want == nil {
+					want = conversion.BuildMap
+				} else if !reflect.DeepEqual(conversion.BuildMap, want) {
+					t.Errorf("the result should not depend on the number of workers: workers=%d", workers)
+				}
+			}
+		})
+	}
+}
+
+func TestMapFromImageCancelled(t *testing.T) {
+	img := benchmarkImage(20, 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, d := range []Dithering{{}, {Mode: ditherFloydSteinberg, Strength: 1}} {
+		lego := Lego{colors: defaultColors, dither: d, workers: 4}
+		conversion, err := lego.mapFromImage(ctx, img)
+
+		var partial *PartialConversionError
+		if !errors.As(err, &partial) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("a cancelled conversion should return a partial result error: err=%v", err)
+		}
+		if conversion == nil || partial.RowsDone >= partial.RowsTotal {
+			t.Errorf("a cancelled conversion should return the rows completed: conversion=%v, err=%v", conversion, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Names of the pieces a mosaic can be built with.
+const (
+	piecePlate      = "plate"
+	pieceTile       = "tile"
+	pieceRoundPlate = "round-plate"
+	pieceRoundTile  = "round-tile"
+	pieceBrick      = "brick"
+)
+
+// Piece is the 1x1 piece every stud of the mosaic is built with.
+type Piece struct {
+	Name string
+	// PartID is the ID of the piece, the same on Rebrickable and BrickLink, and AltPartIDs the IDs
+	// of older molds of the same piece, counted too when reading a stock
+	PartID     string
+	AltPartIDs []string
+	// Height of the piece in millimeters
+	Height float64
+	// Round pieces leave the corners of every stud uncovered
+	Round bool
+	// Stud tells if the piece has a stud on top, as all but tiles have
+	Stud bool
+	// Parts are the larger parts of the same kind the studs can be merged into, 1x1 included
+	Parts []Part
+}
+
+var pieces = map[string]Piece{
+	piecePlate: {Name: piecePlate, PartID: "3024", Height: 3.2, Stud: true, Parts: plateParts},
+	// 3070 is the older mold, without the groove at the bottom
+	pieceTile:       {Name: pieceTile, PartID: "3070b", AltPartIDs: []string{"3070"}, Height: 3.2, Parts: tileParts},
+	pieceRoundPlate: {Name: pieceRoundPlate, PartID: "4073", AltPartIDs: []string{"6141"}, Height: 3.2, Round: true, Stud: true},
+	pieceRoundTile:  {Name: pieceRoundTile, PartID: "98138", Height: 3.2, Round: true},
+	pieceBrick:      {Name: pieceBrick, PartID: "3005", Height: 9.6, Stud: true, Parts: brickParts},
+}
+
+// defaultPiece is the piece used when none is given, a 1x1 plate.
+var defaultPiece = pieces[piecePlate]
+
+// PieceNames returns the names of the supported pieces, sorted.
+func PieceNames() []string {
+	names := make([]string, 0, len(pieces))
+	for name := range pieces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PieceByName returns the piece with the given name.
+func PieceByName(name string) (Piece, error) {
+	p, ok := pieces[name]
+	if !ok {
+		return Piece{}, fmt.Errorf("unknown piece: piece=%q", name)
+	}
+	return p, nil
+}
+
+// orDefault returns the piece, or the default one when it's not set.
+func (p Piece) orDefault() Piece {
+	if p.PartID == "" {
+		return defaultPiece
+	}
+	return p
+}
+
+// partIDs returns the IDs of all the molds of the piece.
+func (p Piece) partIDs() []string {
+	return append([]string{p.PartID}, p.AltPartIDs...)
+}
+
+// tileParts are the tiles a mosaic can be built with.
+var tileParts = []Part{
+	{ID: "3070b", Width: 1, Length: 1},
+	{ID: "3069b", Width: 1, Length: 2},
+	{ID: "63864", Width: 1, Length: 3},
+	{ID: "2431", Width: 1, Length: 4},
+	{ID: "6636", Width: 1, Length: 6},
+	{ID: "4162", Width: 1, Length: 8},
+	{ID: "3068b", Width: 2, Length: 2},
+	{ID: "26603", Width: 2, Length: 3},
+	{ID: "87079", Width: 2, Length: 4},
+}
+
+// brickParts are the bricks a mosaic can be built with.
+var brickParts = []Part{
+	{ID: "3005", Width: 1, Length: 1},
+	{ID: "3004", Width: 1, Length: 2},
+	{ID: "3622", Width: 1, Length: 3},
+	{ID: "3010", Width: 1, Length: 4},
+	{ID: "3009", Width: 1, Length: 6},
+	{ID: "3008", Width: 1, Length: 8},
+	{ID: "3003", Width: 2, Length: 2},
+	{ID: "3002", Width: 2, Length: 3},
+	{ID: "3001", Width: 2, Length: 4},
+	{ID: "2456", Width: 2, Length: 6},
+	{ID: "3007", Width: 2, Length: 8},
+}
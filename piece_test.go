@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPieceByName(t *testing.T) {
+	for _, name := range PieceNames() {
+		p, err := PieceByName(name)
+		if err != nil || p.Name != name {
+			t.Errorf("wrong piece: name=%q, got=%+v, err=%v", name, p, err)
+		}
+		if len(p.Parts) > 0 && p.Parts[0].ID != p.PartID {
+			t.Errorf("the first part of the piece should be the piece itself: name=%q, part=%q", name, p.Parts[0].ID)
+		}
+	}
+	if _, err := PieceByName("slope"); err == nil {
+		t.Errorf("an unknown piece should return an error")
+	}
+	if got, want := pieces[pieceTile].partIDs(), []string{"3070b", "3070"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong part IDs: got=%v, expected=%v", got, want)
+	}
+}
+
+func TestMapFromImageWithPiece(t *testing.T) {
+	palette := []LegoColor{{LegoID: 4, Name: "Red", R: 201, G: 26, B: 9}}
+	img := newUniformRGBA(2, 2, Pixel{R: 200, G: 30, B: 10})
+
+	tests := []struct {
+		name  string
+		piece Piece
+		want  string
+	}{
+		{name: "default", want: "3024"},
+		{name: "tile", piece: pieces[pieceTile], want: "3070b"},
+		{name: "brick", piece: pieces[pieceBrick], want: "3005"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conversion, err := (&Lego{colors: palette, piece: tt.piece}).mapFromImage(context.Background(), img)
+			if err != nil {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if got := conversion.BillOfMaterials[0].PartID; got != tt.want {
+				t.Errorf("wrong part: got=%q, expected=%q", got, tt.want)
+			}
+			if conversion.Piece.PartID != tt.want {
+				t.Errorf("wrong piece: got=%q, expected=%q", conversion.Piece.PartID, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// anyColor is the color ID of the prices that apply to every color of a part.
+const anyColor = -1
+
+type priceKey struct {
+	partID string
+	legoID int
+}
+
+// PriceTable holds the unit price of the parts in every color.
+type PriceTable map[priceKey]float64
+
+// price returns the unit price of the part in the given color, falling back to the price of the
+// part in any color.
+func (t PriceTable) price(partID string, legoID int) (float64, bool) {
+	if p, ok := t[priceKey{partID, legoID}]; ok {
+		return p, true
+	}
+	p, ok := t[priceKey{partID, anyColor}]
+	return p, ok
+}
+
+// priceTableFromCSV reads a price table from a CSV file with the header part,color,price, where
+// color is the color ID. A line with an empty color sets the price of the part in any color.
+func priceTableFromCSV(f io.Reader) (PriceTable, error) {
+	csvReader := csv.NewReader(f)
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse file as CSV: err=%v", err)
+	}
+
+	partColumn, colorColumn, priceColumn := -1, -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "part":
+			partColumn = i
+		case "color":
+			colorColumn = i
+		case "price":
+			priceColumn = i
+		}
+	}
+	if partColumn < 0 || colorColumn < 0 || priceColumn < 0 {
+		return nil, fmt.Errorf("unknown CSV header, expected part,color,price: header=%q", strings.Join(header, ","))
+	}
+
+	prices := make(PriceTable)
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse file as CSV: err=%v", err)
+		}
+		line, _ := csvReader.FieldPos(0)
+
+		part := strings.TrimSpace(record[partColumn])
+		if part == "" {
+			return nil, fmt.Errorf("line %d: missing part", line)
+		}
+		id := anyColor
+		if color := strings.TrimSpace(record[colorColumn]); color != "" {
+			if id, err = strconv.Atoi(color); err != nil {
+				return nil, fmt.Errorf("line %d: invalid color: color=%q", line, record[colorColumn])
+			}
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(record[priceColumn]), 64)
+		if err != nil || price < 0 {
+			return nil, fmt.Errorf("line %d: invalid price: price=%q", line, record[priceColumn])
+		}
+		prices[priceKey{part, id}] = price
+	}
+
+	return prices, nil
+}
+
+// Bill is the estimated cost of the pieces of a conversion.
+type Bill struct {
+	Total float64
+	// Unpriced is the number of pieces without a price, left out of the total
+	Unpriced int
+}
+
+// bill returns the cost of the pieces of the bill of materials.
+func (t PriceTable) bill(bom []BOMEntry) *Bill {
+	b := &Bill{}
+	for _, e := range bom {
+		price, ok := t.price(e.PartID, e.LegoID)
+		if !ok {
+			b.Unpriced += e.Count
+			continue
+		}
+		b.Total += price * float64(e.Count)
+	}
+	return b
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_priceTableFromCSV(t *testing.T) {
+	prices, err := priceTableFromCSV(strings.NewReader("part,color,price\n3024,,0.05\n3024,0,0.02\n3020,0,0.30\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	tests := []struct {
+		partID string
+		legoID int
+		want   float64
+		wantOK bool
+	}{
+		{partID: "3024", legoID: 0, want: 0.02, wantOK: true},
+		{partID: "3024", legoID: 15, want: 0.05, wantOK: true},
+		{partID: "3020", legoID: 0, want: 0.30, wantOK: true},
+		{partID: "3020", legoID: 15},
+	}
+	for _, tt := range tests {
+		if got, ok := prices.price(tt.partID, tt.legoID); got != tt.want || ok != tt.wantOK {
+			t.Errorf("wrong price for part=%s, color=%d: got=%v, ok=%v, expected=%v", tt.partID, tt.legoID, got, ok, tt.want)
+		}
+	}
+
+	for _, csv := range []string{"", "part,price\n3024,1\n", "part,color,price\n3024,0,free\n", "part,color,price\n3024,black,1\n", "part,color,price\n,0,1\n"} {
+		if _, err := priceTableFromCSV(strings.NewReader(csv)); err == nil {
+			t.Errorf("invalid price table should return an error: csv=%q", csv)
+		}
+	}
+}
+
+func TestPriceTableBill(t *testing.T) {
+	prices := PriceTable{{"3024", anyColor}: 0.05, {"3020", 0}: 0.25}
+	bom := []BOMEntry{
+		{PartID: "3024", LegoID: 0, Count: 10},
+		{PartID: "3020", LegoID: 0, Count: 4},
+		{PartID: "3020", LegoID: 15, Count: 3},
+	}
+	if b := prices.bill(bom); b.Total < 1.4999 || b.Total > 1.5001 || b.Unpriced != 3 {
+		t.Errorf("wrong bill: got=%+v", b)
+	}
+}
+
+func Test_partsByCost(t *testing.T) {
+	parts, _ := partsBySize(plateParts, []string{"1x1", "1x2", "2x2", "2x4"})
+	black := LegoColor{LegoID: 0, Name: "Black"}
+	sizes := func(parts []Part) []string {
+		var s []string
+		for _, p := range parts {
+			s = append(s, p.String())
+		}
+		return s
+	}
+
+	if got, want := sizes(partsByCost(parts, black, nil)), []string{"2x4", "2x2", "1x2", "1x1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong parts without prices: got=%v, expected=%v", got, want)
+	}
+	// the 2x2 plate costs more per stud than two 1x2 plates, and the 2x4 plate has no price
+	prices := PriceTable{{"3024", 0}: 0.04, {"3023", 0}: 0.05, {"3022", 0}: 0.12}
+	if got, want := sizes(partsByCost(parts, black, prices)), []string{"1x2", "2x2", "1x1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong parts with prices: got=%v, expected=%v", got, want)
+	}
+	if got, want := sizes(partsByCost(parts, LegoColor{LegoID: 15}, prices)), []string{"1x1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong parts without any price: got=%v, expected=%v", got, want)
+	}
+}
+
+func TestReducePaletteWithPenalties(t *testing.T) {
+	palette := []LegoColor{
+		{LegoID: 4, Name: "Red", R: 201, G: 26, B: 9},
+		{LegoID: 320, Name: "Dark Red", R: 114, G: 14, B: 15},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.SetRGBA(x, y, color.RGBA{R: 170, G: 22, B: 12, A: 255})
+		}
+	}
+
+	reduced, _ := reducePalette(context.Background(), img, rgbMetric{}, palette, 1, nil, nil)
+	if reduced[0].LegoID != 4 {
+		t.Errorf("wrong color without penalties: got=%d, expected=4", reduced[0].LegoID)
+	}
+	reduced, _ = reducePalette(context.Background(), img, rgbMetric{}, palette, 1, []float64{100, 0}, nil)
+	if reduced[0].LegoID != 320 {
+		t.Errorf("wrong color with penalties: got=%d, expected=320", reduced[0].LegoID)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// histogramBits is the number of bits per channel of the histogram used to reduce the palette.
+// Pixels closer than that are counted as the same color, which keeps the reduction fast on
+// large images without changing the result noticeably.
+const histogramBits = 5
+
+// histogramBin is a group of similar pixels of the image, represented by their mean color.
+type histogramBin struct {
+	color  ColorVec
+	weight float64
+}
+
+// colorHistogram groups the pixels of the image by their most significant bits, projecting the
+// mean color of every group into the metric's space.
+func colorHistogram(img *image.RGBA, metric ColorMetric) []histogramBin {
+	const shift = 8 - histogramBits
+	type sum struct{ r, g, b, n int }
+	sums := make(map[int]*sum)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := img.RGBAAt(x, y)
+			key := int(p.R>>shift)<<(2*histogramBits) | int(p.G>>shift)<<histogramBits | int(p.B>>shift)
+			s, ok := sums[key]
+			if !ok {
+				s = &sum{}
+				sums[key] = s
+			}
+			s.r, s.g, s.b, s.n = s.r+int(p.R), s.g+int(p.G), s.b+int(p.B), s.n+1
+		}
+	}
+
+	// sorted by key, so the result doesn't depend on the map order
+	keys := make([]int, 0, len(sums))
+	for key := range sums {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	bins := make([]histogramBin, len(keys))
+	for i, key := range keys {
+		s := sums[key]
+		mean := Pixel{R: (s.r + s.n/2) / s.n, G: (s.g + s.n/2) / s.n, B: (s.b + s.n/2) / s.n}
+		bins[i] = histogramBin{color: metric.Project(mean), weight: float64(s.n)}
+	}
+	return bins
+}
+
+// reducePalette returns the n colors of the palette that represent the image best, in the same
+// order as the palette.
+//
+// It starts with the whole palette and removes, one at a time, the color whose removal adds the
+// least error, the error being the distance between every pixel and its closest color. Removing
+// a color only affects the pixels for which it's the closest color, which move to their second
+// closest one, so every color of the image keeps its candidates sorted by distance.
+//
+// When penalties is set, the error of every pixel also includes the penalty of its color, such as
+// the price of its pieces, so cheaper colors are kept over slightly closer but expensive ones.
+//
+// When capacities is set, it holds the pieces in stock of every color, and a color is only removed
+// if the n colors with the most pieces left are still enough to cover the image.
+func reducePalette(ctx context.Context, img *image.RGBA, metric ColorMetric, colors []LegoColor, n int, penalties []float64, capacities []int) ([]LegoColor, error) {
+	if n <= 0 || n >= len(colors) {
+		return colors, nil
+	}
+
+	pixels := img.Bounds().Dx() * img.Bounds().Dy()
+	removed := make([]bool, len(colors))
+	if capacities != nil {
+		if pieces := topCapacity(capacities, removed, -1, n); pieces < pixels {
+			return nil, fmt.Errorf("not enough pieces in stock to use %d colors: pieces=%d, pixels=%d", n, pieces, pixels)
+		}
+	}
+
+	palette := projectPalette(metric, colors)
+	bins := colorHistogram(img, metric)
+
+	// candidates[i] holds the palette indexes sorted by distance to the bin i, distances[i] the distances
+	candidates := make([][]uint16, len(bins))
+	distances := make([][]float32, len(bins))
+	for i, bin := range bins {
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		distances[i] = make([]float32, len(palette))
+		candidates[i] = make([]uint16, len(palette))
+		for c := range palette {
+			distances[i][c] = float32(metric.Distance(bin.color, palette[c]))
+			if penalties != nil {
+				distances[i][c] += float32(penalties[c])
+			}
+			candidates[i][c] = uint16(c)
+		}
+		d := distances[i]
+		sort.SliceStable(candidates[i], func(a, b int) bool {
+			return d[candidates[i][a]] < d[candidates[i][b]]
+		})
+	}
+
+	// first[i] is the position in candidates[i] of the closest color not removed
+	first := make([]int, len(bins))
+	cost := make([]float64, len(palette))
+	for left := len(palette); left > n; left-- {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		for c := range cost {
+			cost[c] = 0
+		}
+		for i, bin := range bins {
+			for removed[candidates[i][first[i]]] {
+				first[i]++
+			}
+			second := first[i] + 1
+			for removed[candidates[i][second]] {
+				second++
+			}
+			best := candidates[i][first[i]]
+			cost[best] += bin.weight * float64(distances[i][candidates[i][second]]-distances[i][best])
+		}
+
+		worst, worstCost := -1, math.Inf(1)
+		for c := range cost {
+			if removed[c] || cost[c] >= worstCost {
+				continue
+			}
+			if capacities != nil && topCapacity(capacities, removed, c, n) < pixels {
+				// no n of the colors left would have enough pieces
+				continue
+			}
+			worst, worstCost = c, cost[c]
+		}
+		// a color out of the n with the most pieces can always be removed
+		removed[worst] = true
+	}
+
+	reduced := make([]LegoColor, 0, n)
+	for i, c := range colors {
+		if !removed[i] {
+			reduced = append(reduced, c)
+		}
+	}
+	return reduced, nil
+}
+
+// topCapacity returns the pieces of the n colors with the most pieces, leaving out the removed
+// colors and the color skip.
+func topCapacity(capacities []int, removed []bool, skip, n int) int {
+	var left []int
+	for c, capacity := range capacities {
+		if !removed[c] && c != skip {
+			left = append(left, capacity)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(left)))
+	var pieces int
+	for i := 0; i < n && i < len(left); i++ {
+		pieces += left[i]
+	}
+	return pieces
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+var reduceTestPalette = []LegoColor{
+	{LegoID: 4, Name: "Red", R: 201, G: 26, B: 9},
+	{LegoID: 320, Name: "Dark Red", R: 114, G: 14, B: 15},
+	{LegoID: 1, Name: "Blue", R: 0, G: 85, B: 191},
+	{LegoID: 15, Name: "White", R: 255, G: 255, B: 255},
+	{LegoID: 14, Name: "Yellow", R: 242, G: 205, B: 55},
+}
+
+// reduceTestImage returns a 10x10 image, mostly red and blue, with a few dark red pixels.
+func reduceTestImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for x := 0; x < 10; x++ {
+		for y := 0; y < 10; y++ {
+			c := color.RGBA{R: 200, G: 30, B: 10, A: 255}
+			switch {
+			case x >= 5:
+				c = color.RGBA{R: 10, G: 80, B: 190, A: 255}
+			case y == 0:
+				c = color.RGBA{R: 120, G: 20, B: 20, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestReducePalette(t *testing.T) {
+	palette := reduceTestPalette
+	img := reduceTestImage()
+
+	tests := []struct {
+		n       int
+		wantIDs []int
+	}{
+		{n: 1, wantIDs: []int{1}},
+		{n: 2, wantIDs: []int{4, 1}},
+		{n: 3, wantIDs: []int{4, 320, 1}},
+		{n: 5, wantIDs: []int{4, 320, 1, 15, 14}},
+		{n: 0, wantIDs: []int{4, 320, 1, 15, 14}},
+	}
+	for _, tt := range tests {
+		reduced, err := reducePalette(context.Background(), img, oklabMetric{}, palette, tt.n, nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: err=%v", err)
+		}
+		var ids []int
+		for _, c := range reduced {
+			ids = append(ids, c.LegoID)
+		}
+		if !reflect.DeepEqual(ids, tt.wantIDs) {
+			t.Errorf("wrong colors for n=%d: got=%v, expected=%v", tt.n, ids, tt.wantIDs)
+		}
+	}
+}
+
+func TestMapFromImageMaxColors(t *testing.T) {
+	img := benchmarkImage(40, 30)
+	lego := Lego{colors: defaultColors, metric: oklabMetric{}, maxColors: 8}
+	conversion, err := lego.mapFromImage(context.Background(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	if conversion.ColorsUsed > 8 {
+		t.Errorf("too many colors used: got=%d, expected at most 8", conversion.ColorsUsed)
+	}
+	if len(lego.colors) != len(defaultColors) || lego.maxColors != 8 {
+		t.Errorf("the palette of the converter shouldn't change")
+	}
+}
+
+func TestReducePaletteCapacities(t *testing.T) {
+	// of the pairs of colors only White and Yellow have enough pieces together, while a reduction
+	// without stock would keep Red and Blue
+	capacities := []int{40, 5, 40, 50, 50}
+	for n := 1; n <= 3; n++ {
+		reduced, err := reducePalette(context.Background(), reduceTestImage(), oklabMetric{}, reduceTestPalette, n, nil, capacities)
+		if n == 1 {
+			if err == nil {
+				t.Errorf("no color has enough pieces on its own, an error was expected")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: n=%d, err=%v", n, err)
+		}
+		var pieces int
+		for _, c := range reduced {
+			for i, p := range reduceTestPalette {
+				if p.LegoID == c.LegoID {
+					pieces += capacities[i]
+				}
+			}
+		}
+		if len(reduced) != n || pieces < 100 {
+			t.Errorf("wrong colors for n=%d: colors=%d, pieces=%d", n, len(reduced), pieces)
+		}
+	}
+}
+
+func TestMapFromImageMaxColorsWithStock(t *testing.T) {
+	img := reduceTestImage()
+
+	// red and blue are the best pair, but they only have 80 pieces for the 100 pixels
+	stock := Stock{4: 40, 320: 5, 1: 40, 15: 100, 14: 100}
+	lego := Lego{colors: reduceTestPalette, metric: oklabMetric{}, maxColors: 2, stock: stock}
+	conversion, err := lego.mapFromImage(context.Background(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: err=%v", err)
+	}
+	if conversion.ColorsUsed > 2 || conversion.PiecesUsed != 100 {
+		t.Errorf("wrong conversion: colors=%d, pieces=%d", conversion.ColorsUsed, conversion.PiecesUsed)
+	}
+	for _, e := range conversion.BillOfMaterials {
+		if e.Count > stock[e.LegoID] {
+			t.Errorf("more pieces than in stock: color=%d, count=%d, stock=%d", e.LegoID, e.Count, stock[e.LegoID])
+		}
+	}
+
+	// no pair of colors has enough pieces
+	lego.stock = Stock{4: 40, 320: 5, 1: 40, 15: 40, 14: 40}
+	if _, err := lego.mapFromImage(context.Background(), img); err == nil {
+		t.Errorf("a stock without enough pieces for the colors picked should return an error")
+	}
+}
+
+func TestMapFromImageMaxColorsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	lego := Lego{colors: reduceTestPalette, maxColors: 2}
+	conversion, err := lego.mapFromImage(ctx, reduceTestImage())
+	var partial *PartialConversionError
+	if !errors.As(err, &partial) || partial.RowsDone != 0 || partial.RowsTotal != 10 {
+		t.Fatalf("wrong error: err=%v", err)
+	}
+	if conversion == nil || conversion.Image.Bounds().Dx() != 10 || conversion.PiecesUsed != 0 {
+		t.Errorf("an interrupted conversion should come with the rows completed: conversion=%+v", conversion)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// defaultPreviewScale is the side, in pixels, of every stud of the preview when none is given.
+const defaultPreviewScale = 16
+
+// maxPreviewSide is the longest side, in pixels, of the preview. Larger mosaics are drawn with
+// fewer pixels per stud, so the preview of a mural doesn't take gigabytes of memory.
+const maxPreviewSide = 4096
+
+// minStackedPreviewScale is the smallest width, in pixels, of the cells of a stacked preview,
+// the one that keeps the 5:6 and 5:2 shapes of bricks and plates exact.
+const minStackedPreviewScale = 5
+
+// previewGapColor is the color seen between the pieces, that of a black baseplate.
+var previewGapColor = color.RGBA{R: 5, G: 19, B: 29, A: 255}
+
+// previewSamples is the number of samples taken along every axis of a pixel of the preview,
+// which smooths the edges of the studs and of the round pieces.
+const previewSamples = 4
+
+// Shape of the pieces, relative to the side of a cell.
+const (
+	// gapSize is the width of the gap between two pieces, 0.2 mm of the 8 mm of a stud
+	gapSize = 0.025
+	// bevelSize is the width of the bevelled edges of every piece
+	bevelSize = 0.08
+	// studRadius is the radius of the studs, 2.4 mm of the 8 mm of a stud
+	studRadius = 0.3
+	// studShadow is how far the shadow of a stud falls from it, towards the bottom right
+	studShadow = 0.06
+)
+
+// Shading of the pieces, lit from the top left. Factors over 1 move the color towards white and
+// factors under 1 towards black.
+const (
+	lightBevel  = 1.3
+	shadowBevel = 0.65
+	lightStud   = 1.12
+	shadowStud  = 0.75
+	glossTile   = 1.04
+)
+
+// Sides of a cell a piece continues through, such as the studs in the middle of a merged part.
+const (
+	openTop = 1 << iota
+	openRight
+	openBottom
+	openLeft
+)
+
+// cellTemplate holds how every pixel of a cell is drawn, the same for all the cells of the same
+// piece and open sides: cover is the share of the pixel covered by the piece, the rest showing the
+// gap, and light the shading of the part covered.
+type cellTemplate struct {
+	width, height int
+	cover, light  []float64
+}
+
+// newCellTemplate draws a cell of the piece, width pixels wide and height pixels high.
+// Stacked pieces are seen from their side, so they are drawn flat, without a stud.
+func newCellTemplate(piece Piece, width, height int, stacked bool, open int) *cellTemplate {
+	t := &cellTemplate{
+		width:  width,
+		height: height,
+		cover:  make([]float64, width*height),
+		light:  make([]float64, width*height),
+	}
+	// lengths are measured in cell widths, so studs and rounds stay round in any cell
+	w, h := 1.0, float64(height)/float64(width)
+	unit := 1 / float64(width)
+
+	// the face of the piece, inside the gaps of the closed sides
+	left, top, right, bottom := gapSize/2, gapSize/2, w-gapSize/2, h-gapSize/2
+	if open&openLeft != 0 {
+		left = 0
+	}
+	if open&openTop != 0 {
+		top = 0
+	}
+	if open&openRight != 0 {
+		right = w
+	}
+	if open&openBottom != 0 {
+		bottom = h
+	}
+	round := piece.Round && !stacked
+	stud := piece.Stud && !stacked
+	cx, cy := w/2, h/2
+
+	// shade returns the lighting of the point, or false when it isn't on the piece
+	shade := func(x, y float64) (float64, bool) {
+		if x < left || x > right || y < top || y > bottom {
+			return 0, false
+		}
+		light := 1.0
+		if !stud && !stacked {
+			light = glossTile
+		}
+
+		if round {
+			dx, dy := x-cx, y-cy
+			d := math.Hypot(dx, dy)
+			radius := w/2 - gapSize/2
+			if d > radius {
+				return 0, false
+			}
+			if d > radius-bevelSize {
+				// lit by the side of the edge facing the top left
+				light = bevelLight(-(dx + dy) / (d * math.Sqrt2))
+			}
+		} else {
+			// the bevel of the closest closed side
+			edge, closest := 0.0, bevelSize
+			if open&openLeft == 0 && x-left < closest {
+				edge, closest = 1, x-left
+			}
+			if open&openTop == 0 && y-top < closest {
+				edge, closest = 1, y-top
+			}
+			if open&openRight == 0 && right-x < closest {
+				edge, closest = -1, right-x
+			}
+			if open&openBottom == 0 && bottom-y < closest {
+				edge = -1
+			}
+			if edge != 0 {
+				light = bevelLight(edge)
+			}
+		}
+
+		if stud {
+			dx, dy := x-cx, y-cy
+			d := math.Hypot(dx, dy)
+			switch {
+			case d <= studRadius*0.8:
+				light = lightStud
+			case d <= studRadius:
+				light = bevelLight(-(dx + dy) / (d * math.Sqrt2))
+			case math.Hypot(dx-studShadow, dy-studShadow) <= studRadius:
+				light *= shadowStud
+			}
+		}
+		return light, true
+	}
+
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			var covered int
+			var light float64
+			for sy := 0; sy < previewSamples; sy++ {
+				for sx := 0; sx < previewSamples; sx++ {
+					x := (float64(px) + (float64(sx)+0.5)/previewSamples) * unit
+					y := (float64(py) + (float64(sy)+0.5)/previewSamples) * unit
+					if l, ok := shade(x, y); ok {
+						covered++
+						light += l
+					}
+				}
+			}
+			i := py*width + px
+			t.cover[i] = float64(covered) / (previewSamples * previewSamples)
+			if covered > 0 {
+				t.light[i] = light / float64(covered)
+			}
+		}
+	}
+	return t
+}
+
+// bevelLight returns the lighting of a surface facing the light as much as the given factor,
+// from -1, facing away from it, to 1.
+func bevelLight(facing float64) float64 {
+	if facing >= 0 {
+		return 1 + (lightBevel-1)*facing
+	}
+	return 1 + (1-shadowBevel)*facing
+}
+
+// shadeChannel moves the channel towards white by the light over 1, or towards black by the
+// light under 1.
+func shadeChannel(v uint8, light float64) float64 {
+	if light >= 1 {
+		return float64(v) + (255-float64(v))*(light-1)
+	}
+	return float64(v) * light
+}
+
+// draw draws the cell with the given color at the given position of the image.
+func (t *cellTemplate) draw(img *image.RGBA, x, y int, c color.RGBA) {
+	for py := 0; py < t.height; py++ {
+		for px := 0; px < t.width; px++ {
+			i := py*t.width + px
+			cover := t.cover[i]
+			mix := func(v, gap uint8) uint8 {
+				return uint8(math.Round(shadeChannel(v, t.light[i])*cover + float64(gap)*(1-cover)))
+			}
+			img.SetRGBA(x+px, y+py, color.RGBA{
+				R: mix(c.R, previewGapColor.R),
+				G: mix(c.G, previewGapColor.G),
+				B: mix(c.B, previewGapColor.B),
+				A: 255,
+			})
+		}
+	}
+}
+
+// preview draws a mockup of the mosaic with scale pixels per stud, in the style of its piece:
+// every piece with bevelled edges and a gap around it, studs shaded and casting a shadow, tiles
+// flat and glossy, and round pieces leaving the baseplate visible at the corners. The studs of a
+// merged part aren't separated by gaps. A scale of 1 returns the image of the conversion, a pixel
+// per stud.
+//
+// The cells of stacked mosaics are drawn with the shape of the side of their piece, at least
+// minStackedPreviewScale pixels wide. The scale is lowered as previewScale says.
+func (c *Conversion) preview(scale int) *image.RGBA {
+	scale = c.previewScale(scale)
+	if scale <= 1 {
+		return c.Image
+	}
+	cellW, cellH := c.previewCell(scale)
+
+	b := c.Image.Bounds()
+	parts := c.partGrid()
+	// samePart tells if the studs belong to the same merged part
+	samePart := func(x, y, nx, ny int) bool {
+		if parts == nil || nx < 0 || ny < 0 || nx >= b.Dx() || ny >= b.Dy() {
+			return false
+		}
+		return parts[x][y] >= 0 && parts[x][y] == parts[nx][ny]
+	}
+
+	templates := make(map[int]*cellTemplate)
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx()*cellW, b.Dy()*cellH))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			col := c.Image.RGBAAt(b.Min.X+x, b.Min.Y+y)
+			if col.A == 0 {
+				continue
+			}
+			var open int
+			if samePart(x, y, x, y-1) {
+				open |= openTop
+			}
+			if samePart(x, y, x+1, y) {
+				open |= openRight
+			}
+			if samePart(x, y, x, y+1) {
+				open |= openBottom
+			}
+			if samePart(x, y, x-1, y) {
+				open |= openLeft
+			}
+			t, ok := templates[open]
+			if !ok {
+				t = newCellTemplate(c.Piece.orDefault(), cellW, cellH, c.Stacked, open)
+				templates[open] = t
+			}
+			t.draw(img, x*cellW, y*cellH, col)
+		}
+	}
+	return img
+}
+
+// previewScale returns the pixels per stud the preview is drawn with for the given scale: at least
+// minStackedPreviewScale for stacked mosaics, and no more than the ones that keep the preview
+// within maxPreviewSide pixels per side.
+func (c *Conversion) previewScale(scale int) int {
+	if c.Stacked && scale < minStackedPreviewScale {
+		scale = minStackedPreviewScale
+	}
+	b := c.Image.Bounds()
+	for scale > 1 {
+		cellW, cellH := c.previewCell(scale)
+		if b.Dx()*cellW <= maxPreviewSide && b.Dy()*cellH <= maxPreviewSide {
+			break
+		}
+		scale--
+	}
+	return scale
+}
+
+// previewCell returns the width and height, in pixels, of every cell of the preview.
+func (c *Conversion) previewCell(scale int) (int, int) {
+	if c.Stacked {
+		return scale, atLeastOne(float64(scale) * c.Piece.orDefault().stackedAspect())
+	}
+	return scale, scale
+}
+
+// partGrid returns the index in Placements of the part covering every stud, indexed as
+// grid[x][y], or nil when the studs haven't been merged.
+func (c *Conversion) partGrid() [][]int {
+	if len(c.Placements) == 0 {
+		return nil
+	}
+	b := c.Image.Bounds()
+	grid := make([][]int, b.Dx())
+	for x := range grid {
+		grid[x] = make([]int, b.Dy())
+		for y := range grid[x] {
+			grid[x][y] = -1
+		}
+	}
+	for i, p := range c.Placements {
+		w, h := p.size()
+		for x := p.X; x < p.X+w && x < b.Dx(); x++ {
+			for y := p.Y; y < p.Y+h && y < b.Dy(); y++ {
+				grid[x][y] = i
+			}
+		}
+	}
+	return grid
+}
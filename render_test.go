@@ -0,0 +1,117 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestConversionPreview(t *testing.T) {
+	red := color.RGBA{R: 201, G: 26, B: 9, A: 255}
+	c := &Conversion{Image: newUniformRGBA(2, 1, Pixel{R: 201, G: 26, B: 9})}
+	if c.preview(1) != c.Image {
+		t.Errorf("a scale of 1 should return the image of the conversion")
+	}
+
+	brightness := func(c color.RGBA) int { return int(c.R) + int(c.G) + int(c.B) }
+	tests := []struct {
+		piece string
+		// the corner shows the baseplate, between the round pieces
+		gapCorner bool
+		// the center of the cell is the top of a stud
+		stud bool
+	}{
+		{piece: piecePlate, stud: true},
+		{piece: pieceTile},
+		{piece: pieceRoundPlate, gapCorner: true, stud: true},
+		{piece: pieceRoundTile, gapCorner: true},
+		{piece: pieceBrick, stud: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.piece, func(t *testing.T) {
+			c.Piece = pieces[tt.piece]
+			img := c.preview(16)
+			if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 16 {
+				t.Fatalf("wrong preview size: got=%v", b)
+			}
+			if got := img.RGBAAt(16, 0) == previewGapColor; got != tt.gapCorner {
+				t.Errorf("wrong corner: got=%v", img.RGBAAt(16, 0))
+			}
+			center := img.RGBAAt(24, 8)
+			if want := shadeChannel(red.R, lightStud); tt.stud && float64(center.R) != math.Round(want) {
+				t.Errorf("wrong stud color: got=%v", center)
+			}
+			if want := shadeChannel(red.R, glossTile); !tt.stud && float64(center.R) != math.Round(want) {
+				t.Errorf("wrong tile color: got=%v", center)
+			}
+			// lit from the top left
+			if left, right := img.RGBAAt(17, 8), img.RGBAAt(30, 8); brightness(left) <= brightness(right) {
+				t.Errorf("the left edge should be lighter than the right one: left=%v, right=%v", left, right)
+			}
+		})
+	}
+}
+
+func TestConversionPreviewMerged(t *testing.T) {
+	c := &Conversion{Image: newUniformRGBA(2, 1, Pixel{R: 201, G: 26, B: 9}), Piece: pieces[pieceTile]}
+	separate := c.preview(16)
+	c.Placements = []Placement{{Part: tileParts[1], Color: LegoColor{LegoID: 4}}}
+	merged := c.preview(16)
+
+	// no gap nor bevels between the studs of a merged part
+	for _, x := range []int{15, 16} {
+		if got, want := merged.RGBAAt(x, 8), merged.RGBAAt(8, 8); got != want {
+			t.Errorf("wrong color between merged studs: x=%d, got=%v, expected=%v", x, got, want)
+		}
+		if separate.RGBAAt(x, 8) == separate.RGBAAt(8, 8) {
+			t.Errorf("separate pieces should have a bevel between them: x=%d", x)
+		}
+	}
+	// the outer edges are kept
+	if merged.RGBAAt(31, 8) == merged.RGBAAt(8, 8) {
+		t.Errorf("the edges of a merged part should be bevelled")
+	}
+}
+
+func Test_newCellTemplate(t *testing.T) {
+	// a round tile covers a circle of the cell
+	tmpl := newCellTemplate(pieces[pieceRoundTile], 64, 64, false, 0)
+	var cover float64
+	for _, v := range tmpl.cover {
+		cover += v
+	}
+	radius := 0.5 - gapSize/2
+	if got, want := cover/(64*64), math.Pi*radius*radius; math.Abs(got-want) > 0.01 {
+		t.Errorf("wrong area of a round tile: got=%.3f, expected=%.3f", got, want)
+	}
+
+	// a stacked plate is flat, without a stud
+	tmpl = newCellTemplate(pieces[piecePlate], 20, 8, true, 0)
+	if got := tmpl.light[4*20+10]; got != 1 {
+		t.Errorf("wrong light at the center of a stacked plate: got=%.2f", got)
+	}
+}
+
+func TestConversionPreviewBudget(t *testing.T) {
+	// a 1000x1000 mural at 16 pixels per stud would take 1 GB
+	c := &Conversion{Image: newUniformRGBA(1000, 1000, Pixel{R: 201, G: 26, B: 9})}
+	if got := c.previewScale(16); got != 4 {
+		t.Errorf("wrong preview scale: got=%d, expected=4", got)
+	}
+	img := c.preview(16)
+	if b := img.Bounds(); b.Dx() > maxPreviewSide || b.Dy() > maxPreviewSide {
+		t.Errorf("the preview should stay within the budget: got=%v", b)
+	}
+
+	// the cells of stacked bricks are higher than wide
+	c.Stacked, c.Piece = true, pieces[pieceBrick]
+	if got := c.previewScale(16); got != 3 {
+		t.Errorf("wrong stacked preview scale: got=%d, expected=3", got)
+	}
+
+	// small mosaics keep the scale asked for
+	c = &Conversion{Image: newUniformRGBA(100, 50, Pixel{})}
+	if got := c.previewScale(16); got != 16 {
+		t.Errorf("wrong preview scale of a small mosaic: got=%d, expected=16", got)
+	}
+}
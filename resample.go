@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// Supported resampling kernels.
+const (
+	resampleAuto           = "auto"
+	resampleNearest        = "nearest"
+	resampleApproxBiLinear = "approx-bilinear"
+	resampleBiLinear       = "bilinear"
+	resampleCatmullRom     = "catmull-rom"
+	resampleArea           = "area"
+)
+
+// areaDownscaleFactor is how much an image has to be shrunk for the auto resampling
+// to switch from nearest neighbor to the area average.
+const areaDownscaleFactor = 4
+
+var resamplers = map[string]draw.Scaler{
+	resampleNearest:        draw.NearestNeighbor,
+	resampleApproxBiLinear: draw.ApproxBiLinear,
+	resampleBiLinear:       draw.BiLinear,
+	resampleCatmullRom:     draw.CatmullRom,
+	resampleArea:           areaAverage{},
+}
+
+// ResampleModes returns the names of the supported resampling kernels.
+func ResampleModes() []string {
+	return []string{resampleAuto, resampleNearest, resampleApproxBiLinear, resampleBiLinear, resampleCatmullRom, resampleArea}
+}
+
+// resampler returns the scaler for the given kernel. The auto kernel uses the area average when
+// the image is shrunk more than areaDownscaleFactor times, and the nearest neighbor otherwise.
+func resampler(name string, sr, dr image.Rectangle) (draw.Scaler, error) {
+	if name == "" || name == resampleAuto {
+		if sr.Dx() > dr.Dx()*areaDownscaleFactor || sr.Dy() > dr.Dy()*areaDownscaleFactor {
+			return areaAverage{}, nil
+		}
+		return draw.NearestNeighbor, nil
+	}
+	scaler, ok := resamplers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown resampling kernel: resample=%q", name)
+	}
+	return scaler, nil
+}
+
+// areaAverage is a box filter that sets every destination pixel to the average of the source
+// area it covers, weighting the source pixels partially covered by how much of them is inside.
+// When shrinking a photo to a handful of studs, this is what a stud should show.
+type areaAverage struct{}
+
+// areaSpan is the source pixel and how much of it is covered by a destination pixel.
+type areaSpan struct {
+	index  int
+	weight float64
+}
+
+// areaSpans returns, for every destination pixel along one axis, the source pixels it covers.
+func areaSpans(srcMin, srcLen, dstLen int) [][]areaSpan {
+	scale := float64(srcLen) / float64(dstLen)
+	spans := make([][]areaSpan, dstLen)
+	for i := range spans {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for s := int(start); float64(s) < end && s < srcLen; s++ {
+			lo, hi := float64(s), float64(s+1)
+			if lo < start {
+				lo = start
+			}
+			if hi > end {
+				hi = end
+			}
+			spans[i] = append(spans[i], areaSpan{index: srcMin + s, weight: (hi - lo) / scale})
+		}
+	}
+	return spans
+}
+
+func (areaAverage) Scale(dst draw.Image, dr image.Rectangle, src image.Image, sr image.Rectangle, op draw.Op, _ *draw.Options) {
+	dr = dr.Intersect(dst.Bounds())
+	if dr.Empty() || sr.Empty() {
+		return
+	}
+
+	// work on premultiplied RGBA pixels, whatever the source format is
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(sr)
+		draw.Draw(rgba, sr, src, sr.Min, draw.Src)
+	}
+
+	xspans := areaSpans(sr.Min.X, sr.Dx(), dr.Dx())
+	yspans := areaSpans(sr.Min.Y, sr.Dy(), dr.Dy())
+	for j, ys := range yspans {
+		for i, xs := range xspans {
+			var r, g, b, a float64
+			for _, y := range ys {
+				for _, x := range xs {
+					w := x.weight * y.weight
+					p := rgba.RGBAAt(x.index, y.index)
+					r += w * float64(p.R)
+					g += w * float64(p.G)
+					b += w * float64(p.B)
+					a += w * float64(p.A)
+				}
+			}
+
+			c := color.RGBA64{R: uint16(r*0x101 + 0.5), G: uint16(g*0x101 + 0.5), B: uint16(b*0x101 + 0.5), A: uint16(a*0x101 + 0.5)}
+			dx, dy := dr.Min.X+i, dr.Min.Y+j
+			if op == draw.Over && c.A != 0xffff {
+				// composite the premultiplied average over what is already in the destination
+				br, bg, bb, ba := dst.At(dx, dy).RGBA()
+				f := 0xffff - uint32(c.A)
+				c = color.RGBA64{
+					R: c.R + uint16(br*f/0xffff),
+					G: c.G + uint16(bg*f/0xffff),
+					B: c.B + uint16(bb*f/0xffff),
+					A: c.A + uint16(ba*f/0xffff),
+				}
+			}
+			dst.Set(dx, dy, c)
+		}
+	}
+}
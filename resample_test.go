@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestAreaAverage(t *testing.T) {
+	// vertical stripes of one stud, black and white
+	src := image.NewRGBA(image.Rect(0, 0, 12, 12))
+	for x := 0; x < 12; x++ {
+		for y := 0; y < 12; y++ {
+			if x%2 == 0 {
+				src.SetRGBA(x, y, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+			} else {
+				src.SetRGBA(x, y, color.RGBA{A: 255})
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		size int
+		want uint8
+	}{
+		{name: "whole pixels", size: 3, want: 128},
+		// every stud covers 2.4 pixels: 1 + 0.4 white out of 2.4 for the first one
+		{name: "partial pixels", size: 5, want: 149},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := image.NewRGBA(image.Rect(0, 0, tt.size, tt.size))
+			areaAverage{}.Scale(dst, dst.Rect, src, src.Rect, draw.Src, nil)
+
+			if got := dst.RGBAAt(0, 0); absDiff(int(got.R), int(tt.want)) > 1 || got.A != 255 {
+				t.Errorf("wrong average: got=%v, expected=%d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resampler(t *testing.T) {
+	tests := []struct {
+		name string
+		sr   image.Rectangle
+		want draw.Scaler
+	}{
+		{name: resampleAuto, sr: image.Rect(0, 0, 400, 400), want: draw.NearestNeighbor},
+		{name: resampleAuto, sr: image.Rect(0, 0, 401, 100), want: areaAverage{}},
+		{name: resampleCatmullRom, sr: image.Rect(0, 0, 4000, 4000), want: draw.CatmullRom},
+	}
+	for _, tt := range tests {
+		got, err := resampler(tt.name, tt.sr, image.Rect(0, 0, 100, 100))
+		if err != nil || got != tt.want {
+			t.Errorf("wrong scaler: name=%s, sr=%v, got=%T, err=%v", tt.name, tt.sr, got, err)
+		}
+	}
+
+	if _, err := resampler("unknown", image.Rect(0, 0, 1, 1), image.Rect(0, 0, 1, 1)); err == nil {
+		t.Errorf("an unknown kernel should return an error")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Supported resize modes.
+const (
+	resizeStretch = "stretch"
+	resizeFit     = "fit"
+	resizeFill    = "fill"
+	resizeSmart   = "smart"
+	resizePad     = "pad"
+)
+
+// defaultMosaicSize is the width and height used when neither of them is given.
+const defaultMosaicSize = 100
+
+// ResizeOptions describes how the source image is scaled to the size of the mosaic.
+type ResizeOptions struct {
+	// Width and Height of the mosaic in studs. When only one of them is set, the other one
+	// is derived from the aspect ratio of the image.
+	Width  int
+	Height int
+	// Mode decides what happens when the mosaic and the image have different aspect ratios:
+	//   - stretch: the image is distorted to cover the whole mosaic
+	//   - fit: the mosaic is shrunk to the aspect ratio of the image
+	//   - fill: the image is scaled to cover the mosaic and its center is cropped
+	//   - smart: like fill, but cropping the area with the most detail
+	//   - pad: the image is fit inside the mosaic and the borders are filled with Background
+	Mode       string
+	Background color.RGBA
+	// Resample is the kernel used to scale the image, see ResampleModes.
+	Resample string
+	// CellAspect is the height of every cell of the mosaic divided by its width, 1 when not set.
+	// Cells taller or shorter than wide, such as the sides of stacked bricks, get more or less of
+	// the image so it isn't squashed.
+	CellAspect float64
+}
+
+// ResizeModes returns the names of the supported resize modes.
+func ResizeModes() []string {
+	return []string{resizeStretch, resizeFit, resizeFill, resizeSmart, resizePad}
+}
+
+// layout returns the size of the mosaic, the area of the source that is scaled, and where in the mosaic it goes.
+func (o ResizeOptions) layout(source image.Image) (size image.Point, sr, dr image.Rectangle, err error) {
+	sr = source.Bounds()
+	if sr.Empty() {
+		return size, sr, dr, fmt.Errorf("the image is empty")
+	}
+	if o.Width < 0 || o.Height < 0 {
+		return size, sr, dr, fmt.Errorf("invalid mosaic size: width=%d, height=%d", o.Width, o.Height)
+	}
+	if o.Mode != "" && !isResizeMode(o.Mode) {
+		return size, sr, dr, fmt.Errorf("unknown resize mode: mode=%q", o.Mode)
+	}
+	aspect := o.CellAspect
+	if aspect <= 0 {
+		aspect = 1
+	}
+	// the height of the source is measured in cells as wide as a pixel
+	sw, sh := float64(sr.Dx()), float64(sr.Dy())/aspect
+
+	switch {
+	case o.Width == 0 && o.Height == 0:
+		o.Width, o.Height = defaultMosaicSize, defaultMosaicSize
+	case o.Height == 0:
+		size = image.Pt(o.Width, atLeastOne(float64(o.Width)*sh/sw))
+		return size, sr, image.Rectangle{Max: size}, nil
+	case o.Width == 0:
+		size = image.Pt(atLeastOne(float64(o.Height)*sw/sh), o.Height)
+		return size, sr, image.Rectangle{Max: size}, nil
+	}
+
+	size = image.Pt(o.Width, o.Height)
+	fitScale := math.Min(float64(o.Width)/sw, float64(o.Height)/sh)
+	fillScale := math.Max(float64(o.Width)/sw, float64(o.Height)/sh)
+
+	switch o.Mode {
+	case "", resizeStretch:
+		return size, sr, image.Rectangle{Max: size}, nil
+
+	case resizeFit:
+		size = image.Pt(atLeastOne(sw*fitScale), atLeastOne(sh*fitScale))
+		return size, sr, image.Rectangle{Max: size}, nil
+
+	case resizePad:
+		inner := image.Pt(atLeastOne(sw*fitScale), atLeastOne(sh*fitScale))
+		offset := image.Pt((size.X-inner.X)/2, (size.Y-inner.Y)/2)
+		return size, sr, image.Rectangle{Min: offset, Max: offset.Add(inner)}, nil
+
+	case resizeFill, resizeSmart:
+		crop := image.Pt(atLeastOne(float64(o.Width)/fillScale), atLeastOne(float64(o.Height)/fillScale*aspect))
+		if crop.X > sr.Dx() {
+			crop.X = sr.Dx()
+		}
+		if crop.Y > sr.Dy() {
+			crop.Y = sr.Dy()
+		}
+		offset := image.Pt((sr.Dx()-crop.X)/2, (sr.Dy()-crop.Y)/2)
+		if o.Mode == resizeSmart {
+			offset = smartCropOffset(source, crop)
+		}
+		origin := sr.Min.Add(offset)
+		return size, image.Rectangle{Min: origin, Max: origin.Add(crop)}, image.Rectangle{Max: size}, nil
+	}
+
+	return size, sr, dr, fmt.Errorf("unknown resize mode: mode=%q", o.Mode)
+}
+
+func isResizeMode(mode string) bool {
+	for _, m := range ResizeModes() {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func atLeastOne(v float64) int {
+	if r := int(math.Round(v)); r > 1 {
+		return r
+	}
+	return 1
+}
+
+// smartCropSamples is the number of samples taken along every axis of the image to measure its detail.
+const smartCropSamples = 256
+
+// smartCropOffset returns the offset, relative to the image bounds, of the crop with the highest
+// edge energy, that is, the area where the gradient of the luminance is the strongest. As the
+// crop spans the whole image along one of the axis, only its position on the other one is chosen.
+func smartCropOffset(source image.Image, crop image.Point) image.Point {
+	b := source.Bounds()
+	horizontal := crop.X < b.Dx()
+	length, span := b.Dx(), b.Dy()
+	window := crop.X
+	if !horizontal {
+		length, span = b.Dy(), b.Dx()
+		window = crop.Y
+	}
+	if window >= length {
+		return image.Point{}
+	}
+
+	step := float64(length) / smartCropSamples
+	if step < 1 {
+		step = 1
+	}
+	spanStep := float64(span) / smartCropSamples
+	if spanStep < 1 {
+		spanStep = 1
+	}
+
+	luminance := func(along, across int) float64 {
+		x, y := along, across
+		if !horizontal {
+			x, y = across, along
+		}
+		r, g, bl, _ := source.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+	}
+
+	// energy of every sampled position along the free axis, summed across the other one
+	samples := int(float64(length) / step)
+	energy := make([]float64, samples)
+	for i := range energy {
+		along := int(float64(i) * step)
+		next := along + int(step)
+		if next >= length {
+			next = length - 1
+		}
+		for j := 0.0; j < float64(span); j += spanStep {
+			across := int(j)
+			nextAcross := across + int(spanStep)
+			if nextAcross >= span {
+				nextAcross = span - 1
+			}
+			l := luminance(along, across)
+			energy[i] += math.Abs(luminance(next, across)-l) + math.Abs(luminance(along, nextAcross)-l)
+		}
+	}
+
+	// slide the crop over the samples, keeping the window with the most energy
+	windowSamples := int(float64(window) / step)
+	if windowSamples < 1 {
+		windowSamples = 1
+	}
+	var current float64
+	for i := 0; i < windowSamples && i < samples; i++ {
+		current += energy[i]
+	}
+	best, bestStart := current, 0
+	for start := 1; start+windowSamples <= samples; start++ {
+		current += energy[start+windowSamples-1] - energy[start-1]
+		if current > best {
+			best, bestStart = current, start
+		}
+	}
+
+	offset := int(float64(bestStart) * step)
+	if offset+window > length {
+		offset = length - window
+	}
+	if horizontal {
+		return image.Pt(offset, 0)
+	}
+	return image.Pt(0, offset)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func Test_resizePNGImage(t *testing.T) {
+	source := image.NewRGBA(image.Rect(0, 0, 400, 200))
+
+	tests := []struct {
+		name     string
+		opts     ResizeOptions
+		wantSize image.Point
+	}{
+		{name: "default size", opts: ResizeOptions{}, wantSize: image.Pt(100, 100)},
+		{name: "only width", opts: ResizeOptions{Width: 48}, wantSize: image.Pt(48, 24)},
+		{name: "only height", opts: ResizeOptions{Height: 48}, wantSize: image.Pt(96, 48)},
+		{name: "stretch", opts: ResizeOptions{Width: 32, Height: 32, Mode: resizeStretch}, wantSize: image.Pt(32, 32)},
+		{name: "fit", opts: ResizeOptions{Width: 32, Height: 32, Mode: resizeFit}, wantSize: image.Pt(32, 16)},
+		{name: "fill", opts: ResizeOptions{Width: 32, Height: 32, Mode: resizeFill}, wantSize: image.Pt(32, 32)},
+		{name: "smart", opts: ResizeOptions{Width: 32, Height: 32, Mode: resizeSmart}, wantSize: image.Pt(32, 32)},
+		{name: "pad", opts: ResizeOptions{Width: 32, Height: 32, Mode: resizePad}, wantSize: image.Pt(32, 32)},
+		{name: "stacked bricks", opts: ResizeOptions{Width: 40, CellAspect: 1.2}, wantSize: image.Pt(40, 17)},
+		{name: "stacked plates", opts: ResizeOptions{Height: 50, CellAspect: 0.4}, wantSize: image.Pt(40, 50)},
+		{name: "stacked plates fit", opts: ResizeOptions{Width: 32, Height: 32, Mode: resizeFit, CellAspect: 0.4}, wantSize: image.Pt(26, 32)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := resizePNGImage(source, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if m.Rect.Size() != tt.wantSize {
+				t.Errorf("wrong mosaic size: got=%v, expected=%v", m.Rect.Size(), tt.wantSize)
+			}
+		})
+	}
+
+	for _, opts := range []ResizeOptions{
+		{Width: 10, Height: 10, Mode: "unknown"},
+		{Width: 10, Mode: "unknown"},
+		{Height: 10, Mode: "unknown"},
+		{Mode: "unknown"},
+	} {
+		if _, err := resizePNGImage(source, opts); err == nil {
+			t.Errorf("an unknown resize mode should return an error: opts=%+v", opts)
+		}
+	}
+}
+
+func TestResizeOptionsLayout(t *testing.T) {
+	// flat on the left half, a checkerboard full of edges on the right half
+	source := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			c := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			if x >= 200 && (x/4+y/4)%2 == 0 {
+				c = color.RGBA{A: 255}
+			}
+			source.SetRGBA(x, y, c)
+		}
+	}
+
+	tests := []struct {
+		mode   string
+		aspect float64
+		wantSR image.Rectangle
+		wantDR image.Rectangle
+	}{
+		{mode: resizeFill, wantSR: image.Rect(100, 0, 300, 200), wantDR: image.Rect(0, 0, 32, 32)},
+		{mode: resizeSmart, wantSR: image.Rect(200, 0, 400, 200), wantDR: image.Rect(0, 0, 32, 32)},
+		{mode: resizePad, wantSR: image.Rect(0, 0, 400, 200), wantDR: image.Rect(0, 8, 32, 24)},
+		// with cells twice as high as wide, the image measures 400x100 cells
+		{mode: resizeFill, aspect: 2, wantSR: image.Rect(150, 0, 250, 200), wantDR: image.Rect(0, 0, 32, 32)},
+		{mode: resizePad, aspect: 2, wantSR: image.Rect(0, 0, 400, 200), wantDR: image.Rect(0, 12, 32, 20)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			_, sr, dr, err := ResizeOptions{Width: 32, Height: 32, Mode: tt.mode, CellAspect: tt.aspect}.layout(source)
+			if err != nil {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if sr != tt.wantSR || dr != tt.wantDR {
+				t.Errorf("wrong layout: sr=%v, dr=%v, expected sr=%v, dr=%v", sr, dr, tt.wantSR, tt.wantDR)
+			}
+		})
+	}
+}
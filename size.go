@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// studPitchMM is the distance between two studs, the width of a 1x1 piece.
+const studPitchMM = 8.0
+
+// baseplateHeightMM is the thickness of the baseplates the mosaic is built on.
+const baseplateHeightMM = 3.2
+
+// defaultPlateSize is the side, in studs, of the baseplates used to count how many are needed.
+const defaultPlateSize = 32
+
+// MosaicSize is the size of the mosaic as given by the user, in any of the supported units.
+// Only one unit can be used at a time, zero values meaning not given.
+type MosaicSize struct {
+	// Studs, as given by -xlen and -ylen
+	Width, Height int
+	// Baseplates, as given by -plates, of PlateSize studs per side
+	PlatesX, PlatesY int
+	PlateSize        int
+	// Centimeters, as given by -width-cm and -height-cm
+	WidthCM, HeightCM float64
+	// RowHeightMM is the height of every row of the mosaic, the stud pitch when not set. Stacked
+	// mosaics have rows as high as their pieces, and aren't built on baseplates.
+	RowHeightMM float64
+}
+
+// studs converts the size into studs. As with -xlen and -ylen, a zero width or height
+// means that it has to be derived from the aspect ratio of the image.
+func (s MosaicSize) studs() (width, height int, err error) {
+	plates := s.PlatesX > 0 || s.PlatesY > 0
+	cm := s.WidthCM > 0 || s.HeightCM > 0
+	studs := s.Width > 0 || s.Height > 0
+
+	switch {
+	case (plates && cm) || (plates && studs) || (cm && studs):
+		return 0, 0, fmt.Errorf("the mosaic size can only be given in one unit: studs, plates or centimeters")
+	case plates:
+		if s.RowHeightMM > 0 && s.RowHeightMM != studPitchMM {
+			return 0, 0, fmt.Errorf("the size of a stacked mosaic can't be given in baseplates")
+		}
+		if s.PlateSize < 1 {
+			return 0, 0, fmt.Errorf("invalid plate size: size=%d", s.PlateSize)
+		}
+		return s.PlatesX * s.PlateSize, s.PlatesY * s.PlateSize, nil
+	case cm:
+		rowHeight := s.RowHeightMM
+		if rowHeight <= 0 {
+			rowHeight = studPitchMM
+		}
+		return studsFromMM(s.WidthCM * 10), cellsFromMM(s.HeightCM*10, rowHeight), nil
+	}
+	return s.Width, s.Height, nil
+}
+
+// studsFromMM returns how many studs fit the closest to the given length.
+func studsFromMM(mm float64) int {
+	return cellsFromMM(mm, studPitchMM)
+}
+
+// cellsFromMM returns how many cells of the given size fit the closest to the given length.
+func cellsFromMM(mm, cellMM float64) int {
+	if mm <= 0 {
+		return 0
+	}
+	return atLeastOne(mm / cellMM)
+}
+
+// parsePlates parses a size in baseplates with the format "COLUMNSxROWS", e.g. "3x2".
+func parsePlates(plates string) (x, y int, err error) {
+	if plates == "" {
+		return 0, 0, nil
+	}
+	parts := strings.Split(strings.ToLower(plates), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid plates, the format is COLUMNSxROWS: plates=%q", plates)
+	}
+	x, errX := strconv.Atoi(parts[0])
+	y, errY := strconv.Atoi(parts[1])
+	if errX != nil || errY != nil || x < 1 || y < 1 {
+		return 0, 0, fmt.Errorf("invalid plates, the format is COLUMNSxROWS: plates=%q", plates)
+	}
+	return x, y, nil
+}
+
+// physicalSize returns the width, height and depth of the mosaic in millimeters, the depth being
+// the height of its piece on top of the baseplates. Stacked mosaics have rows as high as their
+// piece, and are as deep as a stud.
+func (c *Conversion) physicalSize() (widthMM, heightMM, depthMM float64) {
+	b := c.Image.Bounds()
+	piece := c.Piece.orDefault()
+	if c.Stacked {
+		return float64(b.Dx()) * studPitchMM, float64(b.Dy()) * piece.Height, studPitchMM
+	}
+	return float64(b.Dx()) * studPitchMM, float64(b.Dy()) * studPitchMM, baseplateHeightMM + piece.Height
+}
+
+// baseplates returns how many baseplates are needed to build the mosaic, and their side in studs.
+// Stacked mosaics don't need any.
+func (c *Conversion) baseplates() (count, size int) {
+	size = c.PlateSize
+	if size < 1 {
+		size = defaultPlateSize
+	}
+	if c.Stacked {
+		return 0, size
+	}
+	b := c.Image.Bounds()
+	columns := int(math.Ceil(float64(b.Dx()) / float64(size)))
+	rows := int(math.Ceil(float64(b.Dy()) / float64(size)))
+	return columns * rows, size
+}
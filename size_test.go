@@ -0,0 +1,70 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestMosaicSizeStuds(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       MosaicSize
+		wantWidth  int
+		wantHeight int
+		wantErr    bool
+	}{
+		{name: "studs", size: MosaicSize{Width: 48, Height: 32}, wantWidth: 48, wantHeight: 32},
+		{name: "plates", size: MosaicSize{PlatesX: 3, PlatesY: 2, PlateSize: 32}, wantWidth: 96, wantHeight: 64},
+		{name: "centimeters", size: MosaicSize{WidthCM: 50, HeightCM: 40}, wantWidth: 63, wantHeight: 50},
+		{name: "only width in centimeters", size: MosaicSize{WidthCM: 50}, wantWidth: 63},
+		{name: "plates and studs", size: MosaicSize{Width: 48, PlatesX: 1, PlatesY: 1, PlateSize: 32}, wantErr: true},
+		{name: "centimeters and studs", size: MosaicSize{Height: 48, WidthCM: 20}, wantErr: true},
+		{name: "invalid plate size", size: MosaicSize{PlatesX: 1, PlatesY: 1}, wantErr: true},
+		{name: "stacked bricks in centimeters", size: MosaicSize{WidthCM: 40, HeightCM: 48, RowHeightMM: 9.6}, wantWidth: 50, wantHeight: 50},
+		{name: "stacked plates in baseplates", size: MosaicSize{PlatesX: 1, PlatesY: 1, PlateSize: 32, RowHeightMM: 3.2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, err := tt.size.studs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("wrong size: got=%dx%d, expected=%dx%d", width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func Test_parsePlates(t *testing.T) {
+	if x, y, err := parsePlates("3x2"); err != nil || x != 3 || y != 2 {
+		t.Errorf("wrong plates parsed: x=%d, y=%d, err=%v", x, y, err)
+	}
+	for _, plates := range []string{"3", "3x", "0x2", "axb", "1x2x3"} {
+		if _, _, err := parsePlates(plates); err == nil {
+			t.Errorf("invalid plates should return an error: plates=%q", plates)
+		}
+	}
+}
+
+func TestConversionBaseplates(t *testing.T) {
+	c := &Conversion{Image: image.NewRGBA(image.Rect(0, 0, 50, 20)), PlateSize: 16}
+	if count, size := c.baseplates(); count != 8 || size != 16 {
+		t.Errorf("wrong baseplates: count=%d, size=%d", count, size)
+	}
+	if w, h, d := c.physicalSize(); w != 400 || h != 160 || d != 6.4 {
+		t.Errorf("wrong physical size: got=%.1fx%.1fx%.1f mm", w, h, d)
+	}
+	c.Piece = pieces[pieceBrick]
+	if _, _, d := c.physicalSize(); d != 12.8 {
+		t.Errorf("wrong depth of a brick mosaic: got=%.1f mm", d)
+	}
+
+	c.Stacked = true
+	if count, _ := c.baseplates(); count != 0 {
+		t.Errorf("a stacked mosaic shouldn't need baseplates: count=%d", count)
+	}
+	if w, h, d := c.physicalSize(); w != 400 || h != 192 || d != 8 {
+		t.Errorf("wrong physical size of a stacked mosaic: got=%.1fx%.1fx%.1f mm", w, h, d)
+	}
+}
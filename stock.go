@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stock is the number of pieces owned of every color, by color ID.
+// Colors missing from the stock are not available.
+type Stock map[int]int
+
+// Total returns the number of pieces in the stock.
+func (s Stock) Total() int {
+	var total int
+	for _, quantity := range s {
+		total += quantity
+	}
+	return total
+}
+
+// IDs returns the IDs of the colors in stock, the ones with the most pieces first.
+func (s Stock) IDs() []int {
+	ids := make([]int, 0, len(s))
+	for id, quantity := range s {
+		if quantity > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if s[ids[i]] != s[ids[j]] {
+			return s[ids[i]] > s[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func isPart(parts []string, id string) bool {
+	for _, part := range parts {
+		if strings.EqualFold(part, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// stockFromCSV reads a stock from a CSV file with the header color,quantity, where color is the
+// color ID. The quantities of a color found in several lines are added up.
+//
+// The part lists exported by Rebrickable, with the header Part,Color,Quantity, can be read too:
+// when there is a part column only the pieces of the given parts are counted.
+func stockFromCSV(f io.Reader, parts []string) (Stock, error) {
+	csvReader := csv.NewReader(f)
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse file as CSV: err=%v", err)
+	}
+
+	colorColumn, quantityColumn, partColumn := -1, -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "color":
+			colorColumn = i
+		case "quantity":
+			quantityColumn = i
+		case "part":
+			partColumn = i
+		}
+	}
+	if colorColumn < 0 || quantityColumn < 0 {
+		return nil, fmt.Errorf("unknown CSV header, expected color,quantity: header=%q", strings.Join(header, ","))
+	}
+
+	stock := make(Stock)
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse file as CSV: err=%v", err)
+		}
+		line, _ := csvReader.FieldPos(0)
+		if partColumn >= 0 && !isPart(parts, strings.TrimSpace(record[partColumn])) {
+			continue
+		}
+
+		id, err := strconv.Atoi(strings.TrimSpace(record[colorColumn]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid color: color=%q", line, record[colorColumn])
+		}
+		quantity, err := strconv.Atoi(strings.TrimSpace(record[quantityColumn]))
+		if err != nil || quantity < 0 {
+			return nil, fmt.Errorf("line %d: invalid quantity: quantity=%q", line, record[quantityColumn])
+		}
+		stock[id] += quantity
+	}
+
+	return stock, nil
+}
+
+// loadStock reads the stock from a CSV file or from a BrickLink XML file, told apart by their
+// content, counting only the pieces of the given parts. The BrickLink IDs of the colors missing
+// from the color table are returned, as their pieces can't be used.
+func loadStock(r io.Reader, parts []string, colors ColorIDMap) (Stock, []int, error) {
+	br := bufio.NewReader(r)
+	start, _ := br.Peek(512)
+	if bytes.HasPrefix(bytes.TrimSpace(bytes.TrimPrefix(start, []byte("\xef\xbb\xbf"))), []byte("<")) {
+		return stockFromBrickLinkXML(br, parts, colors)
+	}
+	stock, err := stockFromCSV(br, parts)
+	return stock, nil, err
+}
+
+// StockCost measures how much worse a conversion limited by the stock is than one with unlimited pieces.
+type StockCost struct {
+	// Substituted is the number of pixels that didn't get their closest color
+	Substituted int
+	// MeanDistance is the mean distance, in the metric's space, between the pixels and their color
+	MeanDistance float64
+	// MeanDistanceUnlimited is the mean distance had every color been unlimited
+	MeanDistanceUnlimited float64
+}
+
+// stockCandidates is how many of the closest colors of every pixel are kept as candidates. When
+// all of them run out the candidates are looked for again among the colors left.
+const stockCandidates = 8
+
+// stockAssignment assigns the palette colors to the pixels without using more pieces of a color
+// than the stock has.
+//
+// Pixels are assigned greedily by regret, how much worse their second choice is than the first:
+// the pixels that would lose the most by not getting their closest color pick first, and the ones
+// for which another color is almost as good take the next best color once the closest runs out.
+type stockAssignment struct {
+	metric    ColorMetric
+	palette   []ColorVec
+	remaining []int
+	pixels    []ColorVec
+	// unlimited is the distance from every pixel to its closest color
+	unlimited []float64
+	// candidates holds the closest colors of every pixel, sorted by distance, and next the
+	// position of the candidate the pixel is waiting for
+	candidates [][]stockCandidate
+	next       []int
+}
+
+type stockCandidate struct {
+	color    int
+	distance float64
+}
+
+// assignWithStock sets grid[x][y] to the palette index assigned to every pixel, pixelAt returning the
+// pixel to quantize. It fails when the stock doesn't have enough pieces for the whole image.
+func assignWithStock(ctx context.Context, bounds image.Rectangle, pixelAt func(x, y int) Pixel, m *colorMatcher, colors []LegoColor, stock Stock, grid [][]int) (*StockCost, error) {
+	width, height := bounds.Dx(), bounds.Dy()
+	a := &stockAssignment{
+		metric:     m.metric,
+		palette:    m.palette,
+		remaining:  make([]int, len(colors)),
+		pixels:     make([]ColorVec, width*height),
+		unlimited:  make([]float64, width*height),
+		candidates: make([][]stockCandidate, width*height),
+		next:       make([]int, width*height),
+	}
+
+	// the stock of a color ID found several times in the palette is used by the first of them
+	var available int
+	seen := make(map[int]bool, len(colors))
+	for i, c := range colors {
+		if !seen[c.LegoID] {
+			a.remaining[i] = stock[c.LegoID]
+			available += stock[c.LegoID]
+			seen[c.LegoID] = true
+		}
+	}
+	if available < width*height {
+		return nil, fmt.Errorf("not enough pieces in stock: pieces=%d, pixels=%d", available, width*height)
+	}
+
+	cost := &StockCost{}
+	queue := make(regretQueue, 0, width*height)
+	for y := 0; y < height; y++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			a.pixels[i] = m.metric.Project(pixelAt(x, y))
+			a.unlimited[i] = m.metric.Distance(a.pixels[i], m.palette[m.nearest(a.pixels[i])])
+			cost.MeanDistanceUnlimited += a.unlimited[i]
+			a.candidates[i] = a.closest(a.pixels[i])
+			queue = append(queue, regretItem{pixel: i, color: a.candidates[i][0].color, regret: a.regret(i)})
+		}
+	}
+	heap.Init(&queue)
+
+	for assigned := 0; queue.Len() > 0; {
+		if assigned%4096 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		item := heap.Pop(&queue).(regretItem)
+		i := item.pixel
+		if a.remaining[item.color] == 0 {
+			// the color ran out since the pixel was queued, wait for the next candidate
+			a.advance(i)
+			heap.Push(&queue, regretItem{pixel: i, color: a.candidates[i][a.next[i]].color, regret: a.regret(i)})
+			continue
+		}
+
+		a.remaining[item.color]--
+		grid[i%width][i/width] = item.color
+		distance := a.candidates[i][a.next[i]].distance
+		cost.MeanDistance += distance
+		if distance > a.unlimited[i] {
+			cost.Substituted++
+		}
+		assigned++
+	}
+
+	cost.MeanDistance /= float64(width * height)
+	cost.MeanDistanceUnlimited /= float64(width * height)
+	return cost, nil
+}
+
+// closest returns the colors left closest to the pixel, sorted by distance and then by palette index.
+func (a *stockAssignment) closest(v ColorVec) []stockCandidate {
+	candidates := make([]stockCandidate, 0, stockCandidates+1)
+	for i, p := range a.palette {
+		if a.remaining[i] == 0 {
+			continue
+		}
+		c := stockCandidate{color: i, distance: a.metric.Distance(v, p)}
+		j := len(candidates)
+		for j > 0 && candidates[j-1].distance > c.distance {
+			j--
+		}
+		if j == stockCandidates {
+			continue
+		}
+		candidates = append(candidates, stockCandidate{})
+		copy(candidates[j+1:], candidates[j:])
+		candidates[j] = c
+		if len(candidates) > stockCandidates {
+			candidates = candidates[:stockCandidates]
+		}
+	}
+	return candidates
+}
+
+// advance moves the pixel to its next candidate still in stock, looking for new candidates when
+// all of them ran out.
+func (a *stockAssignment) advance(i int) {
+	for a.next[i]++; a.next[i] < len(a.candidates[i]); a.next[i]++ {
+		if a.remaining[a.candidates[i][a.next[i]].color] > 0 {
+			return
+		}
+	}
+	a.candidates[i] = a.closest(a.pixels[i])
+	a.next[i] = 0
+}
+
+// regret returns how much the pixel loses if it doesn't get its current candidate.
+func (a *stockAssignment) regret(i int) float64 {
+	candidates, next := a.candidates[i], a.next[i]
+	if next+1 >= len(candidates) {
+		// the last color left for the pixel
+		return math.Inf(1)
+	}
+	return candidates[next+1].distance - candidates[next].distance
+}
+
+type regretItem struct {
+	pixel  int
+	color  int
+	regret float64
+}
+
+// regretQueue is a heap of pixels, the one with the highest regret first, and the first pixel of
+// the image among the ones with the same regret so the result is always the same.
+type regretQueue []regretItem
+
+func (q regretQueue) Len() int { return len(q) }
+
+func (q regretQueue) Less(i, j int) bool {
+	if q[i].regret != q[j].regret {
+		return q[i].regret > q[j].regret
+	}
+	return q[i].pixel < q[j].pixel
+}
+
+func (q regretQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *regretQueue) Push(x interface{}) { *q = append(*q, x.(regretItem)) }
+
+func (q *regretQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
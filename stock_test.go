@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_stockFromCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    Stock
+		wantErr bool
+	}{
+		{name: "stock", csv: "color,quantity\n0,100\n15,20\n0,5\n", want: Stock{0: 105, 15: 20}},
+		{name: "columns in any order", csv: "quantity,part,color\n7,3024,4\n", want: Stock{4: 7}},
+		{name: "rebrickable part list", csv: "Part,Color,Quantity,Is Spare\n3024,0,40,False\n3001,0,10,False\n3070b,0,2,True\n98138,15,6,False\n", want: Stock{0: 42, 15: 6}},
+		{name: "unknown header", csv: "id,qty\n0,1\n", wantErr: true},
+		{name: "invalid quantity", csv: "color,quantity\n0,-1\n", wantErr: true},
+		{name: "empty", csv: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stockFromCSV(strings.NewReader(tt.csv), []string{"3024", "3070b", "98138"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: err=%v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrong stock: got=%v, expected=%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapFromImageWithStock(t *testing.T) {
+	palette := []LegoColor{
+		{LegoID: 4, Name: "Red", R: 201, G: 26, B: 9},
+		{LegoID: 25, Name: "Orange", R: 254, G: 138, B: 24},
+		{LegoID: 1, Name: "Blue", R: 0, G: 85, B: 191},
+	}
+	// the left half of the image is red and the right half almost orange
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			c := color.RGBA{R: 200, G: 30, B: 10, A: 255}
+			if x >= 2 {
+				c = color.RGBA{R: 240, G: 110, B: 20, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	t.Run("limited", func(t *testing.T) {
+		lego := Lego{colors: palette, stock: Stock{4: 2, 25: 10, 1: 10}}
+		conversion, err := lego.mapFromImage(context.Background(), img)
+		if err != nil {
+			t.Fatalf("unexpected error: err=%v", err)
+		}
+		counts := make(map[string]int)
+		for x := range conversion.BuildMap {
+			for y := range conversion.BuildMap[x] {
+				name := conversion.BuildMap[x][y][strings.LastIndex(conversion.BuildMap[x][y], "-")+1:]
+				counts[strings.TrimSpace(name)]++
+			}
+		}
+		if want := map[string]int{"Red": 2, "Orange": 6}; !reflect.DeepEqual(counts, want) {
+			t.Errorf("wrong colors used: got=%v, expected=%v", counts, want)
+		}
+		if cost := conversion.StockCost; cost == nil || cost.Substituted != 2 || cost.MeanDistance <= cost.MeanDistanceUnlimited {
+			t.Errorf("wrong stock cost: got=%+v", cost)
+		}
+	})
+
+	t.Run("enough stock", func(t *testing.T) {
+		unlimited, err := (&Lego{colors: palette}).mapFromImage(context.Background(), img)
+		if err != nil {
+			t.Fatalf("unexpected error: err=%v", err)
+		}
+		limited, err := (&Lego{colors: palette, stock: Stock{4: 8, 25: 8}}).mapFromImage(context.Background(), img)
+		if err != nil {
+			t.Fatalf("unexpected error: err=%v", err)
+		}
+		if !reflect.DeepEqual(limited.Image, unlimited.Image) || limited.StockCost.Substituted != 0 {
+			t.Errorf("a large enough stock shouldn't change the conversion: cost=%+v", limited.StockCost)
+		}
+	})
+
+	t.Run("not enough pieces", func(t *testing.T) {
+		lego := Lego{colors: palette, stock: Stock{4: 2, 25: 2, 1: 2}}
+		if _, err := lego.mapFromImage(context.Background(), img); err == nil {
+			t.Errorf("a stock smaller than the image should return an error")
+		}
+	})
+
+	t.Run("error diffusion", func(t *testing.T) {
+		lego := Lego{colors: palette, stock: Stock{4: 10}, dither: Dithering{Mode: ditherFloydSteinberg, Strength: 1}}
+		if _, err := lego.mapFromImage(context.Background(), img); err == nil {
+			t.Errorf("error diffusion with a stock should return an error")
+		}
+	})
+}